@@ -12,4 +12,37 @@ window.popup = (elem, evt) => {
     return !window.open(elem.href);
 };
 
+window.contactSheet = () => {
+    let form = document.getElementById('sheet-form');
+    let dialog = document.getElementById('sheet-dialog');
+    dialog.addEventListener('close', async () => {
+        if (!dialog.returnValue) return;
+
+        let query = new URLSearchParams(new FormData(form));
+        let progress = document.getElementById('progress-dialog');
+        progress.firstChild.textContent = 'Creating contact sheet…';
+        progress.querySelector('progress').removeAttribute('value');
+        progress.showModal();
+        try {
+            await restRequest('GET', '?sheet&' + query);
+        } catch (err) {
+            alertError('Contact sheet failed', err);
+        }
+        progress.close();
+    }, { once: true });
+    dialog.returnValue = '';
+    dialog.showModal();
+};
+
+window.selectPhotos = () => {
+    let form = document.getElementById('walk-form');
+    let dialog = document.getElementById('walk-dialog');
+    dialog.addEventListener('close', () => {
+        if (!dialog.returnValue) return;
+        location.search = '?' + new URLSearchParams(new FormData(form));
+    }, { once: true });
+    dialog.returnValue = '';
+    dialog.showModal();
+};
+
 }();
\ No newline at end of file
@@ -58,18 +58,6 @@ document.documentElement.addEventListener('contextmenu', function (evt) {
     evt.preventDefault();
 });
 
-// Newline-delimited JSON.
-JSON.parseLast = function (ndjson) {
-    var end = ndjson.lastIndexOf('\n');
-    if (end < 0) return void 0;
-    var start = ndjson.lastIndexOf('\n', end - 1);
-    return JSON.parse(ndjson.substring(start, end));
-};
-
-JSON.parseLines = function (ndjson) {
-    return ndjson.trimEnd().split('\n').map(JSON.parse);
-};
-
 // Register dialogs with polyfill, add type=cancel buttons.
 var dialogs = document.querySelectorAll('dialog');
 for (var i = 0; i < dialogs.length; ++i) {
@@ -5,6 +5,7 @@ let edit = document.getElementById('edit') || {};
 let save = document.getElementById('save');
 let zoom = document.getElementById('zoom');
 let white = document.getElementById('white');
+let clipping = document.getElementById('clipping');
 let photo = document.getElementById('photo');
 let print = document.getElementById('print');
 let spinner = document.getElementById('spinner');
@@ -254,6 +255,8 @@ window.saveFile = async () => {
 window.exportFile = async state => {
     if (state === 'dialog') {
         exportChange(document.getElementById('export-form'));
+        loadWatermarks(document.getElementById('export-form'));
+        loadPresets(document.getElementById('export-form'));
         let dialog = document.getElementById('export-dialog');
         dialog.addEventListener('close', () => {
             if (dialog.returnValue) exportFile('export');
@@ -263,21 +266,74 @@ window.exportFile = async state => {
     }
 
     let query = formQuery();
-    if (state === 'export') exportQuery(query);
+    let dryrun = false;
+    if (state === 'export') {
+        exportQuery(query);
+        let form = document.getElementById('export-form');
+        if (form.exists) query.set('exists', form.exists.value);
+        if (form.dryrun && form.dryrun.checked) {
+            query.set('dryrun', '1');
+            dryrun = true;
+        }
+    }
 
     let dialog = document.getElementById('progress-dialog');
     let progress = dialog.querySelector('progress');
     progress.removeAttribute('value');
-    dialog.firstChild.textContent = 'Exporting…';
+    dialog.firstChild.textContent = dryrun ? 'Planning export…' : 'Exporting…';
     dialog.showModal();
     try {
-        await restRequest('POST', '?export&' + query, { progress: progress });
+        let status = await restRequest('POST', '?export&' + query, { progress: progress });
+        if (dryrun && status) await showExportPlan(status.id);
     } catch (err) {
-        alertError('Export failed', err);
+        // a dry run lists the photos that failed along with the plan
+        if (dryrun && err.id) await showExportPlan(err.id);
+        else alertError('Export failed', err);
     }
     dialog.close();
 };
 
+// showExportPlan lists the actions planned by a dry run export job, per photo, then deletes it.
+async function showExportPlan(id) {
+    let job;
+    try {
+        job = await restRequest('GET', '/job/' + id);
+        await restRequest('DELETE', '/job/' + id);
+    } catch (err) {
+        alertError('Export failed', err);
+        return;
+    }
+
+    let dialog = document.getElementById('plan-dialog');
+    let tbody = dialog.querySelector('tbody');
+    tbody.textContent = '';
+
+    let counts = {};
+    let row = (photo, action, file, error) => {
+        let tr = tbody.insertRow();
+        tr.insertCell().textContent = photo;
+        tr.insertCell().textContent = action;
+        let td = tr.insertCell();
+        td.textContent = file;
+        if (error) td.className = 'error';
+        counts[action] = (counts[action] || 0) + 1;
+    };
+    for (let item of job.items) {
+        let reports = item.response || [];
+        if (item.code >= 400) {
+            row(item.name, 'fail', item.text, true);
+        } else if (reports.length === 0) {
+            row(item.name, 'none', '');
+        }
+        reports.forEach((r, i) => row(i ? '' : item.name, r.action, r.file));
+    }
+
+    document.getElementById('plan-summary').textContent = `Export dry run of ${job.items.length} photos: ` +
+        Object.entries(counts).map(([action, n]) => `${n} ${action}`).join(', ') + '.';
+    dialog.returnValue = '';
+    dialog.showModal();
+}
+
 window.printFile = () => {
     if (!print) return;
 
@@ -327,6 +383,13 @@ window.toggleWhite = evt => {
     if (evt && evt.detail) white.blur();
 };
 
+window.toggleClipping = evt => {
+    let clipped = !clipping.classList.contains('pushed');
+    clipping.classList.toggle('pushed', clipped);
+    if (evt && evt.detail) clipping.blur();
+    updatePhoto();
+};
+
 window.showMeta = async () => {
     let html = await htmlRequest('GET', '?meta');
     let dialog = document.getElementById('meta-dialog');
@@ -335,11 +398,62 @@ window.showMeta = async () => {
     dialog.showModal();
 };
 
+window.showDescription = async () => {
+    let form = document.getElementById('description-form');
+    form.reset();
+    for (let e of form.elements) e.defaultValue = '';
+
+    // a single photo shows its description;
+    // for a batch, only changed fields are saved
+    if (photo) {
+        try {
+            let desc = await restRequest('GET', '?description');
+            for (let [k, v] of Object.entries(desc)) {
+                let e = form.elements[k];
+                if (e) e.value = e.defaultValue = Array.isArray(v) ? v.join(', ') : v;
+            }
+        } catch (err) {
+            alertError('Load failed', err);
+            return;
+        }
+    }
+
+    let dialog = document.getElementById('description-dialog');
+    dialog.addEventListener('close', async () => {
+        if (!dialog.returnValue) return;
+
+        let query = new URLSearchParams();
+        for (let e of form.elements) {
+            if (e.name && e.value !== e.defaultValue) query.set(e.name, e.value);
+        }
+        if (query.toString() === '') return;
+
+        let progress = document.getElementById('progress-dialog');
+        progress.firstChild.textContent = 'Saving…';
+        progress.querySelector('progress').removeAttribute('value');
+        progress.showModal();
+        try {
+            await restRequest('POST', '?description&' + query, { progress: progress.querySelector('progress') });
+        } catch (err) {
+            alertError('Save failed', err);
+        }
+        progress.close();
+    }, { once: true });
+    dialog.returnValue = '';
+    dialog.showModal();
+};
+
 window.exportChange = e => {
     let form = e.tagName === 'FORM' ? e : e.form;
 
-    document.getElementById('export-jpeg').hidden = form.format.value !== 'JPEG';
-    document.getElementById('export-dng').hidden = form.format.value === 'JPEG';
+    let custom = form.preset.value === '';
+    document.getElementById('save-preset').disabled = !custom;
+    document.getElementById('export-format').hidden = !custom;
+    document.getElementById('export-name').hidden = !custom;
+    document.getElementById('export-jpeg').hidden = !custom || form.format.value !== 'JPEG' && form.format.value !== 'PNG';
+    document.getElementById('export-dng').hidden = !custom || !form.format.value.startsWith('DNG');
+    document.getElementById('export-color').hidden = !custom || form.format.value.startsWith('DNG');
+    previewNames();
 
     // density unit changed?
     let newden = form.denunit.value;
@@ -416,9 +530,15 @@ window.exportChange = e => {
     let mpix = form.fit.value === 'mpix';
     let dens = form.dimunit.value !== 'px' && !mpix;
 
-    for (let k of ['quality', 'fit', 'long', 'short', 'width', 'height', 'dimunit', 'density', 'denunit', 'mpixels']) {
+    for (let k of ['fit', 'long', 'short', 'width', 'height', 'dimunit', 'density', 'denunit', 'mpixels', 'sharpen', 'sharpenamount']) {
         form[k].disabled = !resample;
     }
+    form.quality.disabled = form.format.value !== 'JPEG';
+    form.colorspace.disabled = form.format.value !== 'TIFF';
+    form.maxsize.disabled = form.format.value !== 'JPEG';
+    form.lossyside.disabled = !form.lossy.checked;
+    form.lossympixels.disabled = !form.lossy.checked;
+    form.compression.options[1].disabled = form.lossy.checked;
 
     if (resample) {
         form.density.disabled = !dens;
@@ -479,7 +599,9 @@ let updatePhoto = function () {
     function load() {
         if (loading) return;
         let newSize = getSize();
-        let newQuery = formQuery().toString();
+        let newQuery = formQuery();
+        if (clipping && clipping.classList.contains('pushed')) newQuery.set('clipping', '1');
+        newQuery = newQuery.toString();
         if (size >= newSize && query === newQuery) {
             spinner.hidden = true;
             loading = false;
@@ -550,26 +672,136 @@ function exportQuery(query) {
     if (query === void 0) query = new URLSearchParams();
 
     let form = document.getElementById('export-form');
-    if (form.format.value !== 'JPEG') {
+    if (form.preset.value) {
+        query.set('preset', form.preset.value);
+        return query;
+    }
+    for (let k of ['template', 'text', 'folder']) {
+        if (form[k].value) query.set(k, form[k].value);
+    }
+    if (form.metadata.value !== 'all') {
+        query.set('metadata', form.metadata.value);
+    }
+    if (!form.format.value.startsWith('DNG') && form.watermark.value) {
+        query.set('watermark', form.watermark.value);
+    }
+    if (form.format.value === 'TIFF') {
+        query.set('tiff', '1');
+        if (form.colorspace.value !== 'sRGB') {
+            query.set('colorspace', form.colorspace.value);
+        }
+        return query;
+    }
+    if (form.format.value === 'PNG') {
+        query.set('png', '1');
+    }
+    if (form.format.value.startsWith('DNG')) {
         query.set('dng', '1');
         query.set('preview', form.preview.value);
-        if (form.format.value === 'DNG+JPEG') {
-            query.set('both', '1');
-        }
-        for (let k of ['lossy', 'embed']) {
+        for (let k of ['lossy', 'embed', 'fastload']) {
             if (form[k].checked) query.set(k, '1');
         }
+        for (let k of ['compression', 'compat']) {
+            if (form[k].value) query.set(k, form[k].value);
+        }
+        if (form.lossy.checked) {
+            for (let k of ['lossyside', 'lossympixels']) {
+                if (form[k].value > 0) query.set(k, form[k].value);
+            }
+        }
+        if (form.format.value === 'DNG+JPEG') {
+            // the DNG, and a JPEG with the same naming and metadata
+            let jpeg = {};
+            for (let k of ['template', 'text', 'folder', 'metadata']) {
+                if (query.has(k)) jpeg[k] = query.get(k);
+            }
+            let dng = Object.assign({
+                dng: true,
+                preview: form.preview.value,
+                lossy: form.lossy.checked,
+                embed: form.embed.checked,
+                fastLoad: form.fastload.checked,
+                compression: form.compression.value,
+                compat: form.compat.value,
+                lossySide: Number(query.get('lossyside')) || 0,
+                lossyMPixels: Number(query.get('lossympixels')) || 0,
+            }, jpeg);
+            query.set('recipes', JSON.stringify([dng, jpeg]));
+        }
     } else if (form.resample.checked) {
         query.set('resample', '1');
-        for (let k of ['quality', 'fit', 'long', 'short', 'width', 'height', 'dimunit', 'density', 'denunit', 'mpixels']) {
+        for (let k of ['fit', 'long', 'short', 'width', 'height', 'dimunit', 'density', 'denunit', 'mpixels', 'sharpen', 'sharpenamount']) {
             if (form[k].value == 0) continue;
             query.set(k, form[k].value);
         }
     }
+    if (form.format.value === 'JPEG') {
+        query.set('quality', form.quality.value);
+    }
+    if (form.format.value === 'JPEG' && form.maxsize.value > 0) {
+        query.set('maxsize', form.maxsize.value);
+    }
 
     return query;
 }
 
+async function previewNames() {
+    let output = document.getElementById('export-names');
+    try {
+        let names = await restRequest('GET', '?filenames&' + exportQuery());
+        let shown = names.filter(n => !n.truncated).slice(0, 3);
+        output.textContent = shown.map(n => n.error || n.export).join(', ');
+        if (names.length > shown.length) output.textContent += ', …';
+    } catch (err) {
+        output.textContent = err.message;
+    }
+}
+
+window.savePreset = async () => {
+    let name = prompt('Preset name:');
+    if (!name) return;
+
+    let form = document.getElementById('export-form');
+    try {
+        await restRequest('POST', '/preset/' + encodeURIComponent(name) + '?' + exportQuery());
+        await loadPresets(form);
+        form.preset.value = name;
+        exportChange(form);
+    } catch (err) {
+        alertError('Save failed', err);
+    }
+};
+
+async function loadPresets(form) {
+    let names;
+    try {
+        names = await restRequest('GET', '/preset/');
+    } catch {
+        return;
+    }
+
+    let select = form.preset;
+    let value = select.value;
+    while (select.options.length > 1) select.remove(1);
+    for (let name of names) select.add(new Option(name, name));
+    select.value = names.includes(value) ? value : '';
+}
+
+async function loadWatermarks(form) {
+    let names;
+    try {
+        names = await restRequest('GET', '/watermark/');
+    } catch {
+        return;
+    }
+
+    let select = form.watermark;
+    let value = select.value;
+    while (select.options.length > 1) select.remove(1);
+    for (let name of names) select.add(new Option(name, name));
+    select.value = names.includes(value) ? value : '';
+}
+
 function restRequest(method, url, { body, progress } = {}) {
     return new Promise((resolve, reject) => {
         let xhr = new XMLHttpRequest();
@@ -614,21 +846,8 @@ function restRequest(method, url, { body, progress } = {}) {
                     reader.readAsText(xhr.response);
                 }
             } else {
-                if (xhr.status === 207 && xhr.getResponseHeader('Content-Type') === 'application/x-ndjson') {
-                    let count = 0;
-                    let lines = JSON.parseLines(xhr.responseText);
-                    for (let status of lines) {
-                        if (status.code >= 400) count++;
-                    }
-                    if (count === 0) {
-                        resolve(xhr.response);
-                    } else {
-                        reject({
-                            status: xhr.status,
-                            name: xhr.statusText,
-                            message: `${count} of ${lines.length} operations failed.`,
-                        });
-                    }
+                if (xhr.status === 202 && xhr.response && xhr.response.id) {
+                    waitJob(xhr.response, progress).then(resolve, reject);
                 } else if (xhr.status < 400) {
                     resolve(xhr.response);
                 } else {
@@ -646,14 +865,6 @@ function restRequest(method, url, { body, progress } = {}) {
         });
         if (progress !== void 0) {
             xhr.onprogress = evt => {
-                if (xhr.status === 207 && xhr.getResponseHeader('Content-Type') === 'application/x-ndjson') {
-                    let last = JSON.parseLast(xhr.responseText);
-                    if (isFinite(last.done) && isFinite(last.total)) {
-                        progress.value = last.done;
-                        progress.max = last.total;
-                    }
-                    return;
-                }
                 if (evt.lengthComputable) {
                     progress.value = evt.loaded;
                     progress.max = evt.total;
@@ -670,6 +881,36 @@ function restRequest(method, url, { body, progress } = {}) {
     });
 }
 
+// waitJob polls a batch job until it's done.
+async function waitJob(status, progress) {
+    for (;;) {
+        if (progress !== void 0 && status.total) {
+            progress.value = status.done;
+            progress.max = status.total;
+        }
+        switch (status.state) {
+            case 'done':
+                if (status.failed) {
+                    throw {
+                        id: status.id,
+                        status: 500,
+                        name: 'Job failed',
+                        message: `${status.failed} of ${status.total} operations failed.`,
+                    };
+                }
+                return status;
+            case 'canceled':
+                throw {
+                    status: 409,
+                    name: 'Job canceled',
+                    message: `${status.done} of ${status.total} operations were done.`,
+                };
+        }
+        await sleep(1000);
+        status = await restRequest('GET', '/job/' + status.id);
+    }
+}
+
 function htmlRequest(method, url) {
     return new Promise((resolve, reject) => {
         let xhr = new XMLHttpRequest();
@@ -5,14 +5,16 @@ import (
 	"context"
 	"encoding/base64"
 	"io"
-	"os"
+	"net/url"
 	"path/filepath"
 	"strings"
 
-	"github.com/ncruces/rethinkraw/pkg/osutil"
+	"github.com/ncruces/rethinkraw/internal/util"
 	"golang.org/x/sync/errgroup"
 )
 
+// Batch paths encode a list of files and folders,
+// optionally followed by options, as a query string entry ("?filter=…").
 func toBatchPath(paths ...string) string {
 	var buf strings.Builder
 	b64 := base64.NewEncoder(base64.RawURLEncoding, &buf)
@@ -41,12 +43,49 @@ func fromBatchPath(path string) []string {
 	return strings.Split(strings.TrimSuffix(str, "\x00"), "\x00")
 }
 
+// splitBatch splits a batch into paths, and options.
+func splitBatch(batch []string) (paths []string, opts url.Values) {
+	opts = url.Values{}
+	for _, path := range batch {
+		if strings.HasPrefix(path, "?") {
+			opts, _ = url.ParseQuery(path[1:])
+		} else {
+			paths = append(paths, path)
+		}
+	}
+	return paths, opts
+}
+
+// joinBatch joins paths, and options, into a batch.
+func joinBatch(paths []string, opts url.Values) []string {
+	if len(opts) == 0 {
+		return paths
+	}
+	return append(paths[:len(paths):len(paths)], "?"+opts.Encode())
+}
+
 type batchPhoto struct {
 	Path string
 	Name string
+	Seq  int
 }
 
-func findPhotos(batch []string) ([]batchPhoto, error) {
+func findPhotos(ctx context.Context, batch []string) ([]batchPhoto, error) {
+	batch, opts := splitBatch(batch)
+
+	walk, err := parseBatchWalk(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter batchFilter
+	if expr := opts.Get("filter"); expr != "" {
+		filter, err = parseBatchFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var photos []batchPhoto
 	for _, path := range batch {
 		var prefix string
@@ -55,26 +94,15 @@ func findPhotos(batch []string) ([]batchPhoto, error) {
 		} else {
 			prefix = path + string(filepath.Separator)
 		}
-		err := filepath.WalkDir(path, func(path string, entry os.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if osutil.HiddenFile(entry) {
-				if entry.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-			if entry.Type().IsRegular() {
-				if _, ok := extensions[strings.ToUpper(filepath.Ext(path))]; ok {
-					var name string
-					if strings.HasPrefix(path, prefix) {
-						name = path[len(prefix):]
-					} else {
-						_, name = filepath.Split(path)
-					}
-					photos = append(photos, batchPhoto{path, name})
+		err := walk.walk(path, func(path string) error {
+			if _, ok := extensions[strings.ToUpper(filepath.Ext(path))]; ok {
+				var name string
+				if strings.HasPrefix(path, prefix) {
+					name = path[len(prefix):]
+				} else {
+					_, name = filepath.Split(path)
 				}
+				photos = append(photos, batchPhoto{path, name, 0})
 			}
 			return nil
 		})
@@ -82,20 +110,46 @@ func findPhotos(batch []string) ([]batchPhoto, error) {
 			return nil, err
 		}
 	}
+
+	if filter != nil {
+		photos, err = filterPhotos(ctx, photos, filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := walk.sortPhotos(ctx, photos); err != nil {
+		return nil, err
+	}
+	for i := range photos {
+		photos[i].Seq = i + 1
+	}
 	return photos, nil
 }
 
-func batchProcess(ctx context.Context, photos []batchPhoto, proc func(ctx context.Context, photo batchPhoto) error) <-chan error {
-	const parallelism = 6
-	output := make(chan error, parallelism)
+// batchResult is the result of processing a photo:
+// an error, or an optional response.
+type batchResult struct {
+	Response any
+	Err      error
+}
+
+func batchProcess(ctx context.Context, photos []batchPhoto, proc func(ctx context.Context, photo batchPhoto) (any, error)) <-chan batchResult {
+	output := make(chan batchResult, workers.max)
 
+	ctx = withBatch(ctx, util.RandomID())
 	go func() {
 		group, ctx := errgroup.WithContext(ctx)
-		group.SetLimit(parallelism)
+		group.SetLimit(workers.max)
 		for _, photo := range photos {
 			photo := photo
 			group.Go(func() error {
-				output <- proc(ctx, photo)
+				if err := workers.acquire(ctx); err != nil {
+					output <- batchResult{nil, err}
+					return nil
+				}
+				defer workers.release(ctx)
+				res, err := proc(ctx, photo)
+				output <- batchResult{res, err}
 				return nil
 			})
 		}
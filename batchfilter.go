@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Batch filters select photos by metadata.
+//
+// A filter is a list of space separated terms, all of which must match.
+// Terms are key:value pairs, and a leading '-' negates a term.
+// Values can list comma separated alternatives, and be quoted.
+//
+//	make:canon  model:"eos r5"  camera:fujifilm  lens:50mm  ext:cr3,nef
+//	iso:100..800  iso:>=1600  date:2023-06-01..2023-06-15  date:2023-06
+//	rating:5  rating:>=4  rating:-1 (rejected)  label:red  edited:no
+//
+// Text matches are case-insensitive; make, model, camera and lens match substrings.
+// Numbers and dates can be ranges (a..b), or comparisons (>a, >=a, <a, <=a).
+// Partial dates (2023, 2023-06) match the whole period.
+type batchFilter []func(*filterMeta) bool
+
+// filterMeta is the metadata batch filters match against.
+type filterMeta struct {
+	Make   string
+	Model  string
+	Lens   string
+	ISO    int
+	Date   time.Time
+	Rating int
+	Label  string
+	Edited bool
+	Ext    string
+}
+
+func parseBatchFilter(expr string) (batchFilter, error) {
+	terms, err := splitFilterTerms(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var res batchFilter
+	for _, term := range terms {
+		negate := strings.HasPrefix(term, "-")
+		key, value, ok := strings.Cut(strings.TrimPrefix(term, "-"), ":")
+		if !ok || value == "" {
+			return nil, errors.New("invalid filter term: " + strconv.Quote(term))
+		}
+
+		var alts []func(*filterMeta) bool
+		for _, v := range strings.Split(value, ",") {
+			match, err := parseFilterValue(strings.ToLower(key), strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter term %q: %w", term, err)
+			}
+			alts = append(alts, match)
+		}
+
+		res = append(res, func(m *filterMeta) bool {
+			for _, match := range alts {
+				if match(m) {
+					return !negate
+				}
+			}
+			return negate
+		})
+	}
+	return res, nil
+}
+
+func (f batchFilter) match(m *filterMeta) bool {
+	for _, match := range f {
+		if !match(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitFilterTerms splits an expression on spaces, except in quotes,
+// and removes the quotes.
+func splitFilterTerms(expr string) ([]string, error) {
+	var terms []string
+	var term strings.Builder
+	var quoted, started bool
+	for _, r := range expr {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case r == ' ' && !quoted:
+			if started {
+				terms = append(terms, term.String())
+			}
+			term.Reset()
+			started = false
+		default:
+			term.WriteRune(r)
+			started = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote in filter")
+	}
+	if started {
+		terms = append(terms, term.String())
+	}
+	return terms, nil
+}
+
+func parseFilterValue(key, value string) (func(*filterMeta) bool, error) {
+	text := func(field func(*filterMeta) string, substr bool) func(*filterMeta) bool {
+		value := strings.ToLower(value)
+		return func(m *filterMeta) bool {
+			f := strings.ToLower(field(m))
+			if substr {
+				return strings.Contains(f, value)
+			}
+			return f == value
+		}
+	}
+
+	switch key {
+	case "make":
+		return text(func(m *filterMeta) string { return m.Make }, true), nil
+	case "model":
+		return text(func(m *filterMeta) string { return m.Model }, true), nil
+	case "camera":
+		return text(func(m *filterMeta) string { return m.Make + " " + m.Model }, true), nil
+	case "lens":
+		return text(func(m *filterMeta) string { return m.Lens }, true), nil
+	case "label":
+		return text(func(m *filterMeta) string { return m.Label }, false), nil
+	case "ext":
+		value = strings.TrimPrefix(value, ".")
+		return text(func(m *filterMeta) string { return m.Ext }, false), nil
+
+	case "iso":
+		lo, hi, err := parseIntRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(m *filterMeta) bool { return m.ISO > 0 && lo <= m.ISO && m.ISO <= hi }, nil
+	case "rating":
+		lo, hi, err := parseIntRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(m *filterMeta) bool { return lo <= m.Rating && m.Rating <= hi }, nil
+
+	case "date":
+		lo, hi, err := parseDateRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(m *filterMeta) bool {
+			return !m.Date.IsZero() && !m.Date.Before(lo) && m.Date.Before(hi)
+		}, nil
+
+	case "edited":
+		var edited bool
+		switch strings.ToLower(value) {
+		case "yes", "true":
+			edited = true
+		case "no", "false":
+			edited = false
+		default:
+			return nil, errors.New("expected yes or no")
+		}
+		return func(m *filterMeta) bool { return m.Edited == edited }, nil
+
+	default:
+		return nil, errors.New("unknown key")
+	}
+}
+
+// parseIntRange parses an inclusive range of integers.
+func parseIntRange(value string) (lo, hi int, err error) {
+	lo, hi = math.MinInt, math.MaxInt
+	parse := func(s string) (int, error) { return strconv.Atoi(strings.TrimSpace(s)) }
+
+	switch {
+	case strings.Contains(value, ".."):
+		a, b, _ := strings.Cut(value, "..")
+		if lo, err = parse(a); err != nil {
+			return
+		}
+		hi, err = parse(b)
+	case strings.HasPrefix(value, ">="):
+		lo, err = parse(value[2:])
+	case strings.HasPrefix(value, "<="):
+		hi, err = parse(value[2:])
+	case strings.HasPrefix(value, ">"):
+		lo, err = parse(value[1:])
+		lo++
+	case strings.HasPrefix(value, "<"):
+		hi, err = parse(value[1:])
+		hi--
+	default:
+		lo, err = parse(value)
+		hi = lo
+	}
+	return
+}
+
+// parseDateRange parses a range of dates, from lo (inclusive) to hi (exclusive).
+func parseDateRange(value string) (lo, hi time.Time, err error) {
+	hi = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	switch {
+	case strings.Contains(value, ".."):
+		a, b, _ := strings.Cut(value, "..")
+		if lo, _, err = parseDatePeriod(a); err != nil {
+			return
+		}
+		_, hi, err = parseDatePeriod(b)
+	case strings.HasPrefix(value, ">="):
+		lo, _, err = parseDatePeriod(value[2:])
+	case strings.HasPrefix(value, "<="):
+		_, hi, err = parseDatePeriod(value[2:])
+	case strings.HasPrefix(value, ">"):
+		_, lo, err = parseDatePeriod(value[1:])
+	case strings.HasPrefix(value, "<"):
+		hi, _, err = parseDatePeriod(value[1:])
+	default:
+		lo, hi, err = parseDatePeriod(value)
+	}
+	return
+}
+
+// parseDatePeriod parses a year, month or day, into its start and end.
+func parseDatePeriod(value string) (start, end time.Time, err error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []struct {
+		format  string
+		y, m, d int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if start, err = time.Parse(layout.format, value); err == nil {
+			return start, start.AddDate(layout.y, layout.m, layout.d), nil
+		}
+	}
+	return start, end, errors.New("invalid date: " + strconv.Quote(value))
+}
+
+// filterPhotos returns the photos that match a filter.
+func filterPhotos(ctx context.Context, photos []batchPhoto, filter batchFilter) ([]batchPhoto, error) {
+	meta, err := loadFilterMeta(ctx, photos)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []batchPhoto
+	for i, photo := range photos {
+		if filter.match(&meta[i]) {
+			res = append(res, photo)
+		}
+	}
+	return res, nil
+}
+
+// Filter metadata is cached, until photos or their sidecars change.
+var filterMetaCache = struct {
+	sync.Mutex
+	all map[string]cachedFilterMeta
+}{all: map[string]cachedFilterMeta{}}
+
+type cachedFilterMeta struct {
+	meta    filterMeta
+	sidecar string
+	modTime [2]time.Time // of the photo, and its sidecar
+}
+
+// loadFilterMeta loads the metadata of photos,
+// reading those not cached (and their sidecars) with a single exiftool command.
+func loadFilterMeta(ctx context.Context, photos []batchPhoto) ([]filterMeta, error) {
+	res := make([]filterMeta, len(photos))
+	cached := make([]cachedFilterMeta, len(photos))
+	index := map[string]int{}
+
+	args := []string{"-json", "-n", "-fast",
+		"-Make", "-Model", "-LensModel", "-ISO", "-DateTimeOriginal",
+		"-XMP-xmp:Rating", "-XMP-xmp:Label", "-XMP-crs:ProcessVersion"}
+	files := len(args)
+
+	filterMetaCache.Lock()
+	for i, photo := range photos {
+		c := &cached[i]
+		c.meta.Ext = strings.TrimPrefix(filepath.Ext(photo.Path), ".")
+		if fi, err := os.Stat(photo.Path); err == nil {
+			c.modTime[0] = fi.ModTime()
+		}
+		if sidecar, err := findSidecar(photo.Path); err == nil {
+			c.sidecar = sidecar
+			if fi, err := os.Stat(sidecar); err == nil {
+				c.modTime[1] = fi.ModTime()
+			}
+		}
+
+		path := filepath.Clean(photo.Path)
+		if old, ok := filterMetaCache.all[path]; ok && old.sidecar == c.sidecar && old.modTime == c.modTime {
+			res[i] = old.meta
+			continue
+		}
+		index[path] = i
+		args = append(args, photo.Path)
+		if c.sidecar != "" {
+			index[filepath.Clean(c.sidecar)] = i
+			args = append(args, c.sidecar)
+		}
+	}
+	filterMetaCache.Unlock()
+
+	if len(args) == files {
+		return res, nil
+	}
+
+	log.Print("exiftool (get filter meta)...")
+	out, err := exifCommand(ctx, args...)
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	var entries []map[string]any
+	if err := json.Unmarshal(out, &entries); err != nil {
+		return nil, err
+	}
+
+	// photos come before their sidecars, so sidecars take precedence
+	for _, entry := range entries {
+		src, _ := entry["SourceFile"].(string)
+		if i, ok := index[filepath.Clean(filepath.FromSlash(src))]; ok {
+			cached[i].meta.load(entry)
+		}
+	}
+
+	filterMetaCache.Lock()
+	defer filterMetaCache.Unlock()
+	if len(filterMetaCache.all) > 10000 {
+		filterMetaCache.all = map[string]cachedFilterMeta{}
+	}
+	for path, i := range index {
+		if filepath.Clean(photos[i].Path) == path {
+			res[i] = cached[i].meta
+			filterMetaCache.all[path] = cached[i]
+		}
+	}
+	return res, nil
+}
+
+// load loads the tags present in an exiftool JSON entry.
+func (m *filterMeta) load(entry map[string]any) {
+	str := func(key string) (string, bool) {
+		v, ok := entry[key]
+		if !ok {
+			return "", false
+		}
+		return strings.TrimSpace(fmt.Sprint(v)), true
+	}
+	num := func(key string) (int, bool) {
+		v, ok := entry[key].(float64)
+		return int(v), ok
+	}
+
+	if v, ok := str("Make"); ok {
+		m.Make = v
+	}
+	if v, ok := str("Model"); ok {
+		m.Model = v
+	}
+	if v, ok := str("LensModel"); ok {
+		m.Lens = v
+	}
+	if v, ok := num("ISO"); ok {
+		m.ISO = v
+	}
+	if v, ok := str("DateTimeOriginal"); ok {
+		m.Date, _ = time.Parse("2006:01:02 15:04:05", v)
+	}
+	if v, ok := num("Rating"); ok {
+		m.Rating = v
+	}
+	if v, ok := str("Label"); ok {
+		m.Label = v
+	}
+	if _, ok := entry["ProcessVersion"]; ok {
+		m.Edited = true
+	}
+}
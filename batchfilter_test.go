@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseBatchFilter(t *testing.T) {
+	meta := filterMeta{
+		Make:   "Canon",
+		Model:  "Canon EOS R5",
+		Lens:   "RF24-105mm F4 L IS USM",
+		ISO:    800,
+		Date:   time.Date(2023, 6, 15, 10, 30, 0, 0, time.UTC),
+		Rating: 4,
+		Label:  "Red",
+		Edited: true,
+		Ext:    "CR3",
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"", true},
+		{"make:canon", true},
+		{"make:nikon", false},
+		{`model:"eos r5"`, true},
+		{"camera:canon lens:24-105", true},
+		{"-lens:24-105", false},
+		{"iso:800", true},
+		{"iso:100..400", false},
+		{"iso:>=800", true},
+		{"iso:>800", false},
+		{"iso:<1600", true},
+		{"date:2023", true},
+		{"date:2023-06", true},
+		{"date:2023-06-16", false},
+		{"date:2023-06-01..2023-06-15", true},
+		{"date:>2023-06-15", false},
+		{"date:<=2023-06-15", true},
+		{"rating:5", false},
+		{"rating:>=4", true},
+		{"rating:-1,4", true},
+		{"label:red", true},
+		{"label:re", false},
+		{"edited:yes", true},
+		{"edited:no", false},
+		{"ext:cr2,.cr3", true},
+		{"ext:nef rating:>=4", false},
+	}
+	for _, tt := range tests {
+		filter, err := parseBatchFilter(tt.expr)
+		if err != nil {
+			t.Fatalf("parseBatchFilter(%q) = %v", tt.expr, err)
+		}
+		if got := filter.match(&meta); got != tt.want {
+			t.Errorf("parseBatchFilter(%q).match() = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"canon", "size:10", "iso:high", "date:june", "edited:maybe", `model:"eos`} {
+		if _, err := parseBatchFilter(expr); err == nil {
+			t.Errorf("parseBatchFilter(%q) should fail", expr)
+		}
+	}
+}
+
+func Test_splitBatch(t *testing.T) {
+	paths := []string{"/photos/a", "/photos/b"}
+	opts := url.Values{"filter": {"rating:5 camera:canon"}}
+
+	batch := fromBatchPath(toBatchPath(joinBatch(paths, opts)...))
+	gotPaths, gotOpts := splitBatch(batch)
+	if !reflect.DeepEqual(gotPaths, paths) || !reflect.DeepEqual(gotOpts, opts) {
+		t.Errorf("splitBatch() = %v, %v", gotPaths, gotOpts)
+	}
+	if got := batchTitle(joinBatch(paths[:1], opts)); got != "a" {
+		t.Errorf("batchTitle() = %q", got)
+	}
+}
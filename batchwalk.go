@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ncruces/rethinkraw/pkg/osutil"
+)
+
+// Batch walks find the photos in a batch's files and folders.
+//
+// Options narrow a walk down:
+//
+//	include=*.cr3;*/selects/*  exclude=_trash  depth=1  symlinks=follow  sort=date
+//
+// Patterns are case-insensitive, and separated by ';'.
+// Patterns with a '/' match paths relative to a folder, others match names.
+// Excluded folders aren't walked; includes only apply to files.
+// A depth of 0 doesn't look into subfolders; by default, all are walked.
+// Symbolic links are skipped, unless followed.
+// Photos are sorted by name, or capture date; by default, they're in walk order.
+type batchWalk struct {
+	include  []string
+	exclude  []string
+	depth    int // negative for unlimited
+	symlinks bool
+	sort     string
+}
+
+func parseBatchWalk(opts url.Values) (batchWalk, error) {
+	w := batchWalk{depth: -1}
+
+	patterns := func(key string) ([]string, error) {
+		var res []string
+		for _, value := range opts[key] {
+			for _, pattern := range strings.Split(value, ";") {
+				pattern = strings.ToLower(strings.Trim(strings.TrimSpace(pattern), "/"))
+				if pattern == "" {
+					continue
+				}
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, errors.New("invalid " + key + " pattern: " + strconv.Quote(pattern))
+				}
+				res = append(res, pattern)
+			}
+		}
+		return res, nil
+	}
+
+	var err error
+	if w.include, err = patterns("include"); err != nil {
+		return w, err
+	}
+	if w.exclude, err = patterns("exclude"); err != nil {
+		return w, err
+	}
+
+	if depth := opts.Get("depth"); depth != "" {
+		w.depth, err = strconv.Atoi(depth)
+		if err != nil || w.depth < 0 {
+			return w, errors.New("invalid depth: " + strconv.Quote(depth))
+		}
+	}
+
+	switch s := opts.Get("symlinks"); s {
+	case "", "skip":
+	case "follow":
+		w.symlinks = true
+	default:
+		return w, errors.New("invalid symlinks option: " + strconv.Quote(s))
+	}
+
+	switch s := opts.Get("sort"); s {
+	case "", "name", "date":
+		w.sort = s
+	default:
+		return w, errors.New("invalid sort order: " + strconv.Quote(s))
+	}
+
+	return w, nil
+}
+
+// matches checks if a relative, slash separated, path matches any of the patterns.
+func (w *batchWalk) matches(patterns []string, rel string) bool {
+	rel = strings.ToLower(rel)
+	name := path.Base(rel)
+	for _, pattern := range patterns {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// walk calls fn for every file under root that isn't hidden, or excluded.
+func (w *batchWalk) walk(root string, fn func(path string) error) error {
+	fi, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+	entry := fs.FileInfoToDirEntry(fi)
+	if osutil.HiddenFile(entry) {
+		return nil
+	}
+
+	visited := map[string]struct{}{}
+	var walk func(path, rel string, entry fs.DirEntry, depth int) error
+	walk = func(path, rel string, entry fs.DirEntry, depth int) error {
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if !w.symlinks {
+				return nil
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				return nil // broken link
+			}
+			entry = fs.FileInfoToDirEntry(fi)
+		}
+
+		if !entry.IsDir() {
+			if entry.Type().IsRegular() && (w.include == nil || w.matches(w.include, rel)) {
+				return fn(path)
+			}
+			return nil
+		}
+
+		if w.depth >= 0 && depth > w.depth {
+			return nil
+		}
+		if w.symlinks {
+			// avoid cycles
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return err
+			}
+			if _, ok := visited[real]; ok {
+				return nil
+			}
+			visited[real] = struct{}{}
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if osutil.HiddenFile(entry) {
+				continue
+			}
+			rel := strings.TrimPrefix(rel+"/"+entry.Name(), "/")
+			if w.matches(w.exclude, rel) {
+				continue
+			}
+			if err := walk(filepath.Join(path, entry.Name()), rel, entry, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if fi.IsDir() {
+		return walk(root, "", entry, 0)
+	}
+	return walk(root, fi.Name(), entry, 0)
+}
+
+// sortPhotos sorts photos, stably, by name or capture date.
+// Photos without a capture date go last.
+func (w *batchWalk) sortPhotos(ctx context.Context, photos []batchPhoto) error {
+	switch w.sort {
+	case "name":
+		sort.SliceStable(photos, func(i, j int) bool {
+			return strings.ToLower(photos[i].Name) < strings.ToLower(photos[j].Name)
+		})
+
+	case "date":
+		meta, err := loadFilterMeta(ctx, photos)
+		if err != nil {
+			return err
+		}
+		index := make([]int, len(photos))
+		for i := range index {
+			index[i] = i
+		}
+		sort.SliceStable(index, func(i, j int) bool {
+			a, b := meta[index[i]].Date, meta[index[j]].Date
+			if a.IsZero() || b.IsZero() {
+				return !a.IsZero() && b.IsZero()
+			}
+			return a.Before(b)
+		})
+		sorted := make([]batchPhoto, len(photos))
+		for i, k := range index {
+			sorted[i] = photos[k]
+		}
+		copy(photos, sorted)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_parseBatchWalk(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false},
+		{"include=*.cr3;*/selects/*&exclude=_trash", false},
+		{"depth=0&symlinks=follow&sort=date", false},
+		{"include=[", true},
+		{"depth=-1", true},
+		{"depth=x", true},
+		{"symlinks=maybe", true},
+		{"sort=size", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			opts, _ := url.ParseQuery(tt.query)
+			_, err := parseBatchWalk(opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseBatchWalk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_findPhotos_walk(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"b.CR3", "a.nef", "notes.txt", ".hidden.cr3",
+		"2023/selects/c.cr3", "2023/d.cr3",
+		"_trash/e.cr3", ".git/f.cr3",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(dir, filepath.Join(dir, "2023", "loop")); err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"sort=name", []string{"2023/d.cr3", "2023/selects/c.cr3", "_trash/e.cr3", "a.nef", "b.CR3"}},
+		{"sort=name&depth=0", []string{"a.nef", "b.CR3"}},
+		{"sort=name&depth=1", []string{"2023/d.cr3", "_trash/e.cr3", "a.nef", "b.CR3"}},
+		{"sort=name&exclude=_trash", []string{"2023/d.cr3", "2023/selects/c.cr3", "a.nef", "b.CR3"}},
+		{"sort=name&include=*/selects/*", []string{"2023/selects/c.cr3"}},
+		{"sort=name&include=*.cr3&exclude=2023/selects", []string{"2023/d.cr3", "_trash/e.cr3", "b.CR3"}},
+		{"sort=name&symlinks=follow&exclude=_trash%3Bselects", []string{"2023/d.cr3", "a.nef", "b.CR3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			photos, err := findPhotos(context.Background(), []string{dir, "?" + tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for i, photo := range photos {
+				if photo.Seq != i+1 {
+					t.Errorf("findPhotos() Seq = %d, want %d", photo.Seq, i+1)
+				}
+				got = append(got, filepath.ToSlash(photo.Name))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findPhotos() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/schema"
+	"github.com/ncruces/rethinkraw/internal/config"
+	"github.com/ncruces/rethinkraw/pkg/dngconv"
+	"github.com/ncruces/rethinkraw/pkg/wine"
+)
+
+// Commands run RethinkRAW headless, from the command line:
+//
+//	rethinkraw export [OPTION]... PATH...
+//	rethinkraw convert [OPTION]... PATH...
+//	rethinkraw apply-settings -from SETTINGS [OPTION]... PATH...
+//	rethinkraw settings get PHOTO
+//	rethinkraw settings set KEY=VALUE... PATH...
+//	rethinkraw wb [-at X,Y] [-save] [OPTION]... PATH...
+//
+// Paths are photos, or folders walked for photos.
+// Progress goes to standard output, failures to standard error.
+// Commands exit with 1 if any photo fails, and 2 on usage errors.
+type command struct {
+	args  string // usage
+	help  string
+	setup func(fs *flag.FlagSet) func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"export": {
+		args:  "[OPTION]... PATH...",
+		help:  "Exports photos, with their edits, into a folder.",
+		setup: exportCommand,
+	},
+	"convert": {
+		args:  "[OPTION]... PATH...",
+		help:  "Converts photos, with their edits, to DNG.",
+		setup: convertCommand,
+	},
+	"apply-settings": {
+		args:  "-from SETTINGS [OPTION]... PATH...",
+		help:  "Applies edit settings, from a JSON file (see settings get) or a photo, to photos.",
+		setup: applySettingsCommand,
+	},
+	"settings": {
+		args:  "get PHOTO | set KEY=VALUE... PATH...",
+		help:  "Prints the edit settings of a photo as JSON, or changes settings of photos.",
+		setup: settingsCommand,
+	},
+	"wb": {
+		args:  "[-at X,Y] [-save] [OPTION]... PATH...",
+		help:  "Computes the white balance of photos: as shot, or neutral at a point.",
+		setup: wbCommand,
+	},
+}
+
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// errUsage reports invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// runCommand runs a command, and returns its exit code.
+func runCommand(name string, args []string) int {
+	cmd := commands[name]
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "usage: %s %s %s\n", filepath.Base(os.Args[0]), name, cmd.args)
+		fmt.Fprintln(w, cmd.help)
+		fs.PrintDefaults()
+	}
+	verbose := fs.Bool("v", false, "log the tools invoked")
+	nproc := fs.Int("workers", 0, "the maximum `number` of photos processed in parallel (default: the number of CPUs)")
+	run := cmd.setup(fs)
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	setupWorkers(*nproc)
+
+	err := startHeadless(func(ctx context.Context) error {
+		return run(ctx, fs.Args())
+	})
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		fs.Usage()
+		return 2
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+}
+
+// startHeadless sets up what processing photos needs, without the server, and calls fn.
+// It uses its own temporary folder, so that it can run alongside the app.
+func startHeadless(fn func(ctx context.Context) error) error {
+	if err := config.SetupPaths(); err != nil {
+		return err
+	}
+	temp, err := os.MkdirTemp("", "RethinkRAW-")
+	if err != nil {
+		return err
+	}
+	config.TempDir = temp
+	defer os.RemoveAll(temp)
+
+	if runtime.GOOS != "windows" && runtime.GOOS != "darwin" {
+		wine.Startup()
+		defer wine.Shutdown()
+	}
+	if !dngconv.IsInstalled() {
+		return errors.New("Please download and install Adobe DNG Converter.")
+	}
+
+	exif, err := setupExifTool()
+	if err != nil {
+		return err
+	}
+	defer exif.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-shutdown
+		cancel()
+	}()
+	return fn(ctx)
+}
+
+// formFlag is a flag that sets a form value, for the decoders shared with the UI.
+type formFlag struct {
+	form    url.Values
+	key     string
+	boolean bool
+}
+
+func (f *formFlag) String() string {
+	if f.form == nil {
+		return ""
+	}
+	return strings.Join(f.form[f.key], ";")
+}
+
+func (f *formFlag) Set(value string) error {
+	f.form.Add(f.key, value)
+	return nil
+}
+
+func (f *formFlag) IsBoolFlag() bool { return f.boolean }
+
+func formFlags(fs *flag.FlagSet, form url.Values, flags [][3]string) {
+	for _, f := range flags {
+		name, kind, usage := f[0], f[1], f[2]
+		fs.Var(&formFlag{form: form, key: name, boolean: kind == "bool"}, name, usage)
+	}
+}
+
+// walkFlags are the flags that select photos, see batchWalk and batchFilter.
+func walkFlags(fs *flag.FlagSet, form url.Values) {
+	formFlags(fs, form, [][3]string{
+		{"include", "", "include only files matching glob `patterns` (separated by ';')"},
+		{"exclude", "", "exclude files and folders matching glob `patterns` (separated by ';')"},
+		{"depth", "", "the maximum `depth` of subfolders walked (0: none)"},
+		{"symlinks", "", "skip or follow symbolic links (`policy`: skip, follow)"},
+		{"sort", "", "process photos in `order`: name, date"},
+		{"filter", "", "process only photos matching a metadata `filter` (e.g. 'rating:>=4 camera:canon')"},
+	})
+}
+
+// findCommandPhotos finds the photos in paths, with the walk options in form.
+func findCommandPhotos(ctx context.Context, paths []string, form url.Values) ([]batchPhoto, error) {
+	if len(paths) == 0 {
+		return nil, errUsage
+	}
+	batch := make([]string, len(paths))
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		batch[i] = abs
+	}
+
+	opts := url.Values{}
+	for _, key := range batchOptionKeys {
+		if values, ok := form[key]; ok {
+			opts[key] = values
+		}
+	}
+
+	photos, err := findPhotos(ctx, joinBatch(batch, opts))
+	if err != nil {
+		return nil, err
+	}
+	if len(photos) == 0 {
+		return nil, errors.New("no RAW photos found")
+	}
+	return photos, nil
+}
+
+// processPhotos processes photos in parallel, printing progress,
+// and fails if any photo fails. Transient failures are retried.
+func processPhotos(ctx context.Context, photos []batchPhoto, proc func(ctx context.Context, photo batchPhoto) (string, error)) error {
+	results := batchProcess(ctx, photos, func(ctx context.Context, photo batchPhoto) (any, error) {
+		msg, _, err := retry(ctx, photo.Name, func() (any, error) {
+			return proc(ctx, photo)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", photo.Name, err)
+		}
+		return photo.Name + ": " + msg.(string), nil
+	})
+
+	var done, failed int
+	for res := range results {
+		done++
+		if res.Err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "[%d/%d] %v\n", done, len(photos), res.Err)
+		} else {
+			fmt.Printf("[%d/%d] %v\n", done, len(photos), res.Response)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d photos failed", failed, len(photos))
+	}
+	return nil
+}
+
+func exportCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	form := url.Values{}
+	dir := fs.String("o", ".", "the output `directory`")
+	walkFlags(fs, form)
+	formFlags(fs, form, [][3]string{
+		{"preset", "", "export with the named `preset` (repeat for several outputs)"},
+		{"exists", "", "when an exported file exists: uniquify, skip or overwrite (`policy`)"},
+		{"dryrun", "bool", "print what would be exported, without exporting"},
+		{"dng", "bool", "export DNG"},
+		{"tiff", "bool", "export TIFF"},
+		{"png", "bool", "export PNG"},
+		{"quality", "", "the JPEG `quality`, 1-12 (default 10)"},
+		{"resample", "bool", "resize the exported image"},
+		{"fit", "", "how to resize: dims, size, mpix (`mode`)"},
+		{"long", "", "the long side `size`, when fitting dims"},
+		{"short", "", "the short side `size`, when fitting dims"},
+		{"width", "", "the `width`, when fitting size"},
+		{"height", "", "the `height`, when fitting size"},
+		{"dimunit", "", "the `unit` of sizes: px, in, cm"},
+		{"density", "", "the print `density`"},
+		{"denunit", "", "the `unit` of density: ppi, ppc"},
+		{"mpixels", "", "the `megapixels`, when fitting mpix"},
+		{"maxsize", "", "the maximum JPEG file `size`, in MB"},
+		{"colorspace", "", "the TIFF color `space`: sRGB, AdobeRGB, DisplayP3, ProPhotoRGB"},
+		{"sharpen", "", "output sharpening for: screen, matte, glossy (`medium`)"},
+		{"sharpenamount", "", "output sharpening `amount`: low, standard, high"},
+		{"metadata", "", "the metadata to keep: all, nogps, copyright, none (`policy`)"},
+		{"watermark", "", "the watermark `preset`"},
+		{"template", "", "the file name `template` (e.g. '{name}-{seq}')"},
+		{"text", "", "custom `text`, for file name templates"},
+		{"folder", "", "the output `subfolder` template"},
+		{"preview", "", "the DNG preview `size`: p0 (none), p1 (medium), p2 (full)"},
+		{"embed", "bool", "embed the original RAW in DNGs"},
+		{"compat", "", "the DNG `compatibility` (e.g. cr16.0)"},
+		{"compression", "", "the DNG `compression`: uncompressed, jxl"},
+		{"fastload", "bool", "embed fast load data in DNGs"},
+		{"lossy", "bool", "use lossy DNG compression"},
+		{"lossyside", "", "the long side `size` of lossy DNGs"},
+		{"lossympixels", "", "the `megapixels` of lossy DNGs"},
+	})
+	return func(ctx context.Context, args []string) error {
+		return runExport(ctx, *dir, form, args)
+	}
+}
+
+func convertCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	form := url.Values{"dng": {"true"}}
+	dir := fs.String("o", ".", "the output `directory`")
+	walkFlags(fs, form)
+	formFlags(fs, form, [][3]string{
+		{"exists", "", "when a converted file exists: uniquify, skip or overwrite (`policy`)"},
+		{"dryrun", "bool", "print what would be converted, without converting"},
+		{"template", "", "the file name `template` (e.g. '{name}-{seq}')"},
+		{"folder", "", "the output `subfolder` template"},
+		{"preview", "", "the DNG preview `size`: p0 (none), p1 (medium), p2 (full)"},
+		{"embed", "bool", "embed the original RAW"},
+		{"compat", "", "the DNG `compatibility` (e.g. cr16.0)"},
+		{"compression", "", "the DNG `compression`: uncompressed, jxl"},
+		{"fastload", "bool", "embed fast load data"},
+		{"lossy", "bool", "use lossy compression"},
+		{"lossyside", "", "the long side `size` of lossy DNGs"},
+		{"lossympixels", "", "the `megapixels` of lossy DNGs"},
+		{"metadata", "", "the metadata to keep: all, nogps, copyright, none (`policy`)"},
+	})
+	return func(ctx context.Context, args []string) error {
+		return runExport(ctx, *dir, form, args)
+	}
+}
+
+// runExport exports photos, each with its own edit, into dir.
+func runExport(ctx context.Context, dir string, form url.Values, args []string) error {
+	recipes, err := decodeExportRecipes(form)
+	if err != nil {
+		return err
+	}
+	run, err := decodeExportRun(form)
+	if err != nil {
+		return err
+	}
+	for _, exp := range recipes {
+		if exp.DNG {
+			if _, err := exp.dngArgs(); err != nil {
+				return err
+			}
+		}
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+
+	photos, err := findCommandPhotos(ctx, args, form)
+	if err != nil {
+		return err
+	}
+
+	params := jobParams{Kind: "export", Dir: dir, Recipes: recipes, exportRun: run}
+	return processPhotos(ctx, photos, func(ctx context.Context, photo batchPhoto) (string, error) {
+		p := params
+		xmp, err := loadEdit(ctx, photo.Path)
+		if err != nil {
+			return "", err
+		}
+		p.XMP = xmp
+
+		report, err := batchProcessPhoto(ctx, photo, &p)
+		if err != nil {
+			return "", err
+		}
+		var msg []string
+		for _, r := range report {
+			msg = append(msg, r.Action+" "+r.File)
+		}
+		return strings.Join(msg, ", "), nil
+	})
+}
+
+func applySettingsCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	form := url.Values{}
+	from := fs.String("from", "", "the edit settings to apply: a JSON `file`, or a photo")
+	walkFlags(fs, form)
+	return func(ctx context.Context, args []string) error {
+		if *from == "" {
+			return errUsage
+		}
+
+		var xmp xmpSettings
+		if strings.EqualFold(filepath.Ext(*from), ".json") {
+			data, err := os.ReadFile(*from)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &xmp); err != nil {
+				return err
+			}
+		} else {
+			var err error
+			if xmp, err = loadEdit(ctx, *from); err != nil {
+				return err
+			}
+		}
+		// each photo keeps its orientation
+		xmp.Orientation = 0
+
+		photos, err := findCommandPhotos(ctx, args, form)
+		if err != nil {
+			return err
+		}
+		return processPhotos(ctx, photos, func(ctx context.Context, photo batchPhoto) (string, error) {
+			xmp := xmp
+			xmp.Filename = filepath.Base(photo.Path)
+			return "saved", saveEdit(ctx, photo.Path, xmp)
+		})
+	}
+}
+
+func settingsCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	form := url.Values{}
+	walkFlags(fs, form)
+	return func(ctx context.Context, args []string) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+
+		switch args[0] {
+		case "get":
+			if len(args) != 2 {
+				return errUsage
+			}
+			xmp, err := loadEdit(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(xmp)
+
+		case "set":
+			values := url.Values{}
+			args = args[1:]
+			for len(args) > 0 {
+				key, value, ok := strings.Cut(args[0], "=")
+				if !ok {
+					break
+				}
+				values.Add(key, value)
+				args = args[1:]
+			}
+			if len(values) == 0 {
+				return errUsage
+			}
+
+			photos, err := findCommandPhotos(ctx, args, form)
+			if err != nil {
+				return err
+			}
+			return processPhotos(ctx, photos, func(ctx context.Context, photo batchPhoto) (string, error) {
+				xmp, err := loadEdit(ctx, photo.Path)
+				if err != nil {
+					return "", err
+				}
+				dec := schema.NewDecoder()
+				if err := dec.Decode(&xmp, values); err != nil {
+					return "", err
+				}
+				xmp.Filename = filepath.Base(photo.Path)
+				return "saved", saveEdit(ctx, photo.Path, xmp)
+			})
+
+		default:
+			return errUsage
+		}
+	}
+}
+
+func wbCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	form := url.Values{}
+	at := fs.String("at", "", "the neutral `point`, as fractions of the unrotated image (e.g. 0.5,0.5)")
+	save := fs.Bool("save", false, "save the white balance to the photos' edits")
+	walkFlags(fs, form)
+	return func(ctx context.Context, args []string) error {
+		var coords []float64
+		if *at != "" {
+			x, y, ok := strings.Cut(*at, ",")
+			if !ok {
+				return errUsage
+			}
+			for _, s := range []string{x, y} {
+				f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+				if err != nil || f < 0 || f > 1 {
+					return errUsage
+				}
+				coords = append(coords, f)
+			}
+		}
+
+		photos, err := findCommandPhotos(ctx, args, form)
+		if err != nil {
+			return err
+		}
+		return processPhotos(ctx, photos, func(ctx context.Context, photo batchPhoto) (string, error) {
+			wb, err := loadWhiteBalance(ctx, photo.Path, coords)
+			if err != nil {
+				return "", err
+			}
+			if wb.Temperature == 0 {
+				return "", errors.New("white balance failed")
+			}
+			msg := fmt.Sprintf("temperature %d, tint %+d", wb.Temperature, wb.Tint)
+			if !*save {
+				return msg, nil
+			}
+
+			xmp, err := loadEdit(ctx, photo.Path)
+			if err != nil {
+				return "", err
+			}
+			xmp.Filename = filepath.Base(photo.Path)
+			xmp.WhiteBalance = "Custom"
+			xmp.Temperature, xmp.Tint = wb.Temperature, wb.Tint
+			return msg + ", saved", saveEdit(ctx, photo.Path, xmp)
+		})
+	}
+}
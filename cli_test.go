@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/url"
+	"testing"
+)
+
+func Test_runCommand_usage(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"-h"}, 0},
+		{[]string{}, 2},
+		{[]string{"-bogus", "photo.dng"}, 2},
+	}
+	for _, tt := range tests {
+		for name := range commands {
+			if got := runCommand(name, tt.args); got != tt.want {
+				t.Errorf("runCommand(%q, %q) = %d, want %d", name, tt.args, got, tt.want)
+			}
+		}
+	}
+}
+
+func Test_formFlags(t *testing.T) {
+	form := url.Values{}
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	walkFlags(fs, form)
+	formFlags(fs, form, [][3]string{
+		{"tiff", "bool", ""},
+		{"resample", "bool", ""},
+		{"fit", "", ""},
+		{"long", "", ""},
+		{"dimunit", "", ""},
+		{"colorspace", "", ""},
+	})
+
+	err := fs.Parse([]string{"-tiff", "-resample", "-fit", "dims", "-long", "2048", "-dimunit", "px",
+		"-colorspace", "DisplayP3", "-include", "*.cr3", "-include", "*.nef", "photos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Args(); len(got) != 1 || got[0] != "photos" {
+		t.Errorf("Args() = %q", got)
+	}
+	if got := form["include"]; len(got) != 2 {
+		t.Errorf("include = %q", got)
+	}
+
+	recipes, err := decodeExportRecipes(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := exportSettings{TIFF: true, Resample: true, Fit: "dims", Long: 2048, DimUnit: "px", ColorSpace: "DisplayP3"}
+	if len(recipes) != 1 || recipes[0] != want {
+		t.Errorf("decodeExportRecipes() = %+v, want %+v", recipes, want)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/ncruces/rethinkraw/pkg/dcraw"
+)
+
+// An RGB color space for exports.
+type colorSpace struct {
+	name  string        // profile description
+	prim  [3][2]float64 // xy chromaticities of the red, green, and blue primaries
+	white [2]float64    // xy chromaticity of the white point
+	gamma float64       // tone response curve exponent
+	slope float64       // linear toe slope of the sRGB curve, or 0 for a pure power law
+	dcraw dcraw.ColorSpace
+}
+
+var (
+	srgbSpace = colorSpace{
+		name:  "sRGB IEC61966-2.1",
+		prim:  [3][2]float64{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}},
+		white: d65White,
+		gamma: 2.4, slope: 12.92,
+		dcraw: dcraw.SRGB,
+	}
+	adobeSpace = colorSpace{
+		name:  "Adobe RGB (1998)",
+		prim:  [3][2]float64{{0.64, 0.33}, {0.21, 0.71}, {0.15, 0.06}},
+		white: d65White,
+		gamma: 563.0 / 256,
+		dcraw: dcraw.AdobeRGB,
+	}
+	p3Space = colorSpace{
+		name:  "Display P3",
+		prim:  [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}},
+		white: d65White,
+		gamma: 2.4, slope: 12.92,
+	}
+	prophotoSpace = colorSpace{
+		name:  "ProPhoto RGB",
+		prim:  [3][2]float64{{0.7347, 0.2653}, {0.1596, 0.8404}, {0.0366, 0.0001}},
+		white: d50White,
+		gamma: 1.8,
+		dcraw: dcraw.ProPhotoRGB,
+	}
+
+	d50White = [2]float64{0.3457, 0.3585}
+	d65White = [2]float64{0.3127, 0.3290}
+)
+
+// JPEG and PNG exports are rendered by Camera Raw, which only renders sRGB previews;
+// TIFF exports are developed from camera data, and support every color space.
+var colorSpaces = map[string]*colorSpace{
+	"sRGB":        &srgbSpace,
+	"AdobeRGB":    &adobeSpace,
+	"DisplayP3":   &p3Space,
+	"ProPhotoRGB": &prophotoSpace,
+}
+
+type mat3 [3][3]float64
+
+func (a mat3) mul(b mat3) (m mat3) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+func (a mat3) vec(v [3]float64) (r [3]float64) {
+	for i := 0; i < 3; i++ {
+		r[i] = a[i][0]*v[0] + a[i][1]*v[1] + a[i][2]*v[2]
+	}
+	return r
+}
+
+func (a mat3) inv() (m mat3) {
+	det := a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			i1, i2 := (j+1)%3, (j+2)%3
+			j1, j2 := (i+1)%3, (i+2)%3
+			m[i][j] = (a[i1][j1]*a[i2][j2] - a[i1][j2]*a[i2][j1]) / det
+		}
+	}
+	return m
+}
+
+func xyToXYZ(xy [2]float64) [3]float64 {
+	return [3]float64{xy[0] / xy[1], 1, (1 - xy[0] - xy[1]) / xy[1]}
+}
+
+// toXYZ returns the matrix that converts linear RGB into XYZ (relative to the space's white point).
+func (cs *colorSpace) toXYZ() mat3 {
+	var m mat3
+	for j, xy := range cs.prim {
+		xyz := xyToXYZ(xy)
+		for i := 0; i < 3; i++ {
+			m[i][j] = xyz[i]
+		}
+	}
+	s := m.inv().vec(xyToXYZ(cs.white))
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] *= s[j]
+		}
+	}
+	return m
+}
+
+// bradford returns the matrix that adapts XYZ from a white point to another.
+func bradford(src, dst [2]float64) mat3 {
+	b := mat3{
+		{+0.8951, +0.2664, -0.1614},
+		{-0.7502, +1.7135, +0.0367},
+		{+0.0389, -0.0685, +1.0296},
+	}
+	s := b.vec(xyToXYZ(src))
+	d := b.vec(xyToXYZ(dst))
+	return b.inv().mul(mat3{{d[0] / s[0]}, {1: d[1] / s[1]}, {2: d[2] / s[2]}}).mul(b)
+}
+
+// conversion returns the matrix that converts linear RGB from a color space to another.
+func (cs *colorSpace) conversion(from *colorSpace) mat3 {
+	return cs.toXYZ().inv().mul(bradford(from.white, cs.white)).mul(from.toXYZ())
+}
+
+func (cs *colorSpace) toLinear(v float64) float64 {
+	if cs.slope == 0 {
+		return math.Pow(v, cs.gamma)
+	}
+	if v <= 0.04045 {
+		return v / cs.slope
+	}
+	return math.Pow((v+0.055)/1.055, cs.gamma)
+}
+
+func (cs *colorSpace) fromLinear(v float64) float64 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 1
+	case cs.slope == 0:
+		return math.Pow(v, 1/cs.gamma)
+	case v <= 0.0031308:
+		return v * cs.slope
+	}
+	return 1.055*math.Pow(v, 1/cs.gamma) - 0.055
+}
+
+// convertImage converts an 8-bit image from a color space to another.
+func convertImage(img image.Image, from, to *colorSpace) *image.RGBA {
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	if from == to {
+		return rgba
+	}
+
+	var linear [256]float64
+	for i := range linear {
+		linear[i] = from.toLinear(float64(i) / 255)
+	}
+	m := to.conversion(from)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		rgb := m.vec([3]float64{
+			linear[rgba.Pix[i+0]],
+			linear[rgba.Pix[i+1]],
+			linear[rgba.Pix[i+2]],
+		})
+		rgba.Pix[i+0] = uint8(255*to.fromLinear(rgb[0]) + 0.5)
+		rgba.Pix[i+1] = uint8(255*to.fromLinear(rgb[1]) + 0.5)
+		rgba.Pix[i+2] = uint8(255*to.fromLinear(rgb[2]) + 0.5)
+	}
+	return rgba
+}
+
+// convertRGB48 converts, in place, a 16-bit image from a color space to another.
+func convertRGB48(img *rgb48, from, to *colorSpace) {
+	const bins = 1 << 16
+	linear := make([]float32, bins)
+	for i := range linear {
+		linear[i] = float32(from.toLinear(float64(i) / (bins - 1)))
+	}
+
+	m := to.conversion(from)
+	be := binary.BigEndian
+	for i := 0; i < len(img.Pix); i += 6 {
+		rgb := m.vec([3]float64{
+			float64(linear[be.Uint16(img.Pix[i+0:])]),
+			float64(linear[be.Uint16(img.Pix[i+2:])]),
+			float64(linear[be.Uint16(img.Pix[i+4:])]),
+		})
+		be.PutUint16(img.Pix[i+0:], uint16((bins-1)*to.fromLinear(rgb[0])+0.5))
+		be.PutUint16(img.Pix[i+2:], uint16((bins-1)*to.fromLinear(rgb[1])+0.5))
+		be.PutUint16(img.Pix[i+4:], uint16((bins-1)*to.fromLinear(rgb[2])+0.5))
+	}
+}
+
+// iccProfile creates a version 2, matrix/TRC display profile for the color space.
+func (cs *colorSpace) iccProfile() []byte {
+	s15f16 := func(v float64) uint32 {
+		return uint32(int32(math.Round(v * 65536)))
+	}
+	xyzType := func(xyz [3]float64) []byte {
+		buf := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range xyz {
+			buf = binary.BigEndian.AppendUint32(buf, s15f16(v))
+		}
+		return buf
+	}
+
+	var desc []byte
+	desc = append(desc, "desc\x00\x00\x00\x00"...)
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(cs.name)+1))
+	desc = append(desc, cs.name...)
+	desc = append(desc, 0)
+	desc = append(desc, make([]byte, 4+4+2+1+67)...) // empty Unicode and ScriptCode
+
+	cprt := append([]byte("text\x00\x00\x00\x00No copyright, use freely"), 0)
+
+	var trc []byte
+	trc = append(trc, "curv\x00\x00\x00\x00"...)
+	if cs.slope == 0 {
+		trc = binary.BigEndian.AppendUint32(trc, 1)
+		trc = binary.BigEndian.AppendUint16(trc, uint16(math.Round(cs.gamma*256)))
+	} else {
+		const n = 1024
+		trc = binary.BigEndian.AppendUint32(trc, n)
+		for i := 0; i < n; i++ {
+			v := cs.toLinear(float64(i) / (n - 1))
+			trc = binary.BigEndian.AppendUint16(trc, uint16(math.Round(v*65535)))
+		}
+	}
+
+	// colorants are adapted to the D50 profile connection space
+	m := bradford(cs.white, d50White).mul(cs.toXYZ())
+	column := func(j int) [3]float64 { return [3]float64{m[0][j], m[1][j], m[2][j]} }
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"cprt", cprt},
+		{"wtpt", xyzType(xyToXYZ(cs.white))},
+		{"rXYZ", xyzType(column(0))},
+		{"gXYZ", xyzType(column(1))},
+		{"bXYZ", xyzType(column(2))},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	// tag data is 4-byte aligned, the tone response curves are shared
+	table := make([]byte, 0, 4+12*len(tags))
+	table = binary.BigEndian.AppendUint32(table, uint32(len(tags)))
+	var body []byte
+	var trcOffset, trcSize uint32
+	offset := uint32(128 + cap(table))
+	for _, tag := range tags {
+		table = append(table, tag.sig...)
+		if tag.sig[1:] == "TRC" && trcOffset != 0 {
+			table = binary.BigEndian.AppendUint32(table, trcOffset)
+			table = binary.BigEndian.AppendUint32(table, trcSize)
+			continue
+		}
+		pos := offset + uint32(len(body))
+		table = binary.BigEndian.AppendUint32(table, pos)
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+		if tag.sig[1:] == "TRC" {
+			trcOffset, trcSize = pos, uint32(len(tag.data))
+		}
+		body = append(body, tag.data...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(len(header)+len(table)+len(body)))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntrRGB XYZ ")
+	binary.BigEndian.PutUint16(header[24:], 2000) // date: 2000-01-01
+	binary.BigEndian.PutUint16(header[26:], 1)
+	binary.BigEndian.PutUint16(header[28:], 1)
+	copy(header[36:], "acsp")
+	binary.BigEndian.PutUint32(header[68:], s15f16(0.9642)) // D50 illuminant
+	binary.BigEndian.PutUint32(header[72:], s15f16(1.0000))
+	binary.BigEndian.PutUint32(header[76:], s15f16(0.8249))
+
+	res := append(header, table...)
+	return append(res, body...)
+}
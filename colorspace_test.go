@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func Test_colorSpace_toXYZ(t *testing.T) {
+	want := mat3{
+		{0.4124, 0.3576, 0.1805},
+		{0.2126, 0.7152, 0.0722},
+		{0.0193, 0.1192, 0.9505},
+	}
+	got := srgbSpace.toXYZ()
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(got[i][j]-want[i][j]) > 1e-3 {
+				t.Fatalf("toXYZ() = %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func Test_colorSpace_conversion(t *testing.T) {
+	for name, cs := range colorSpaces {
+		// white maps to white
+		white := cs.conversion(&srgbSpace).vec([3]float64{1, 1, 1})
+		for _, v := range white {
+			if math.Abs(v-1) > 1e-3 {
+				t.Errorf("%s: conversion(white) = %v", name, white)
+			}
+		}
+		// curves round trip
+		for _, v := range []float64{0, 0.001, 0.04, 0.5, 1} {
+			if got := cs.toLinear(cs.fromLinear(v)); math.Abs(got-v) > 1e-9 {
+				t.Errorf("%s: toLinear(fromLinear(%v)) = %v", name, v, got)
+			}
+		}
+	}
+}
+
+func Test_convertRGB48(t *testing.T) {
+	pix := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // white
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // black
+		0xff, 0xff, 0x00, 0x00, 0x00, 0x00, // red
+	}
+	img := &rgb48{Pix: pix, Width: 3, Height: 1}
+	convertRGB48(img, &srgbSpace, &prophotoSpace)
+
+	be := binary.BigEndian
+	at := func(x, c int) int { return int(be.Uint16(img.Pix[6*x+2*c:])) }
+	for c := 0; c < 3; c++ {
+		if v := at(0, c); v < 65535-16 {
+			t.Errorf("convertRGB48(white) = %d", v)
+		}
+		if v := at(1, c); v != 0 {
+			t.Errorf("convertRGB48(black) = %d", v)
+		}
+	}
+	// sRGB red is inside the wider ProPhoto gamut
+	if r, g, b := at(2, 0), at(2, 1), at(2, 2); r >= 65535 || g == 0 || b == 0 {
+		t.Errorf("convertRGB48(red) = %d, %d, %d", r, g, b)
+	}
+}
+
+func Test_colorSpace_iccProfile(t *testing.T) {
+	for name, cs := range colorSpaces {
+		icc := cs.iccProfile()
+		if size := binary.BigEndian.Uint32(icc); int(size) != len(icc) {
+			t.Errorf("%s: profile size = %d, want %d", name, size, len(icc))
+		}
+		if string(icc[36:40]) != "acsp" {
+			t.Errorf("%s: missing profile signature", name)
+		}
+		count := int(binary.BigEndian.Uint32(icc[128:]))
+		for i := 0; i < count; i++ {
+			tag := icc[132+12*i:]
+			offset := binary.BigEndian.Uint32(tag[4:])
+			size := binary.BigEndian.Uint32(tag[8:])
+			if offset%4 != 0 || int(offset+size) > len(icc) {
+				t.Errorf("%s: bad tag %q", name, tag[:4])
+			}
+		}
+	}
+}
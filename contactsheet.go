@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ncruces/rethinkraw/internal/util"
+	"github.com/ncruces/rethinkraw/pkg/pdf"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/sync/errgroup"
+)
+
+// Contact sheets lay out edited thumbnails in a grid,
+// captioned with file name, rating and capture info.
+// PDF sheets are paged; JPEG sheets are a single (tall) image.
+type sheetSettings struct {
+	Format  string // pdf or jpeg
+	Columns int
+	Paper   string // a4 or letter
+}
+
+// Layout, in points.
+const (
+	sheetMargin  = 36
+	sheetHeader  = 24
+	sheetGap     = 10
+	sheetCaption = 7
+	sheetLeading = 1.3 * sheetCaption
+	sheetThumb   = 640 // thumbnail size, in pixels
+	sheetScale   = 3   // JPEG pixels per point
+)
+
+type sheetPhoto struct {
+	Name   string
+	Thumb  image.Image
+	JPEG   []byte
+	Rating int
+	Meta   photoMeta
+}
+
+func (s *sheetSettings) pageSize() (width, height float64) {
+	if s.Paper == "letter" {
+		return 612, 792
+	}
+	return 595, 842
+}
+
+func (s *sheetSettings) columns() int {
+	if s.Columns < 1 {
+		return 4
+	}
+	if s.Columns > 10 {
+		return 10
+	}
+	return s.Columns
+}
+
+// cellSize returns the size of each grid cell, and of the thumbnail box in it.
+func (s *sheetSettings) cellSize() (width, height, box float64) {
+	pageWidth, _ := s.pageSize()
+	width = (pageWidth - 2*sheetMargin) / float64(s.columns())
+	box = width - sheetGap
+	height = box + sheetGap + 2*sheetLeading
+	return width, height, box
+}
+
+// rows returns the number of rows that fit in a PDF page.
+func (s *sheetSettings) rows() int {
+	_, pageHeight := s.pageSize()
+	_, cellHeight, _ := s.cellSize()
+	return max(1, int((pageHeight-2*sheetMargin-sheetHeader)/cellHeight))
+}
+
+// cellOrigin returns the top-left corner of the thumbnail box of the i-th cell,
+// from the top of the page.
+func (s *sheetSettings) cellOrigin(i int) (x, y float64) {
+	cellWidth, cellHeight, _ := s.cellSize()
+	col, row := i%s.columns(), i/s.columns()
+	x = sheetMargin + float64(col)*cellWidth + sheetGap/2
+	y = sheetMargin + sheetHeader + float64(row)*cellHeight
+	return x, y
+}
+
+// fitThumb returns the position and size of a thumbnail,
+// centered in a box of the given size.
+func fitThumb(img image.Image, box float64) (dx, dy, width, height float64) {
+	size := img.Bounds().Size()
+	scale := box / float64(max(size.X, size.Y))
+	width = float64(size.X) * scale
+	height = float64(size.Y) * scale
+	return (box - width) / 2, (box - height) / 2, width, height
+}
+
+// loadSheetPhotos renders the thumbnails, and loads the metadata, of photos.
+func loadSheetPhotos(ctx context.Context, photos []batchPhoto) ([]sheetPhoto, error) {
+	res := make([]sheetPhoto, len(photos))
+
+	group, ctx := errgroup.WithContext(withBatch(ctx, util.RandomID()))
+	group.SetLimit(workers.max)
+	for i, photo := range photos {
+		i, photo := i, photo
+		group.Go(func() (err error) {
+			if err := workers.acquire(ctx); err != nil {
+				return err
+			}
+			defer workers.release(ctx)
+			res[i], err = loadSheetPhoto(ctx, photo)
+			return err
+		})
+	}
+	return res, group.Wait()
+}
+
+func loadSheetPhoto(ctx context.Context, photo batchPhoto) (res sheetPhoto, err error) {
+	res.Name = photo.Name
+
+	xmp, err := loadEdit(ctx, photo.Path)
+	if err != nil {
+		return res, err
+	}
+	data, err := renderEdit(ctx, photo.Path, sheetThumb, xmp)
+	if err != nil {
+		return res, err
+	}
+
+	// apply orientation, and reencode for embedding
+	res.Thumb, err = decodeJPEG(data)
+	if err != nil {
+		return res, err
+	}
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, res.Thumb, &jpeg.Options{Quality: 85}); err != nil {
+		return res, err
+	}
+	res.JPEG = buf.Bytes()
+
+	res.Meta, err = loadPhotoMeta(ctx, photo.Path)
+	if err != nil {
+		return res, err
+	}
+	res.Rating, err = loadRating(ctx, photo.Path)
+	return res, err
+}
+
+// loadRating loads the rating of a photo (-1 for rejected).
+func loadRating(ctx context.Context, path string) (int, error) {
+	wk, err := openWorkspace(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	defer wk.close()
+
+	log.Print("exiftool (get rating)...")
+	out, err := exifCommand(ctx, "-short3", "-forcePrint", "-fast", "-n", "-XMP-xmp:Rating", wk.origXMP())
+	if err != nil {
+		return 0, err
+	}
+	rating, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+	return rating, nil
+}
+
+// captions returns the caption lines for a photo, shortened to fit in width.
+// The file name is shortened before the rating.
+func (p *sheetPhoto) captions(width float64, measure func(string) float64) [2]string {
+	var rating string
+	switch {
+	case p.Rating < 0:
+		rating = "  (rejected)"
+	case p.Rating > 0:
+		rating = "  " + strings.Repeat("*", min(p.Rating, 5))
+	}
+	name := fitText(p.Name, width-measure(rating), measure) + rating
+
+	var info []string
+	if t, err := strconv.ParseFloat(p.Meta.ExposureTime, 64); err == nil && t > 0 {
+		if t < 0.5 {
+			info = append(info, fmt.Sprintf("1/%.0f s", 1/t))
+		} else {
+			info = append(info, fmt.Sprintf("%g s", math.Round(t*10)/10))
+		}
+	}
+	if f, err := strconv.ParseFloat(p.Meta.FNumber, 64); err == nil && f > 0 {
+		info = append(info, fmt.Sprintf("f/%g", f))
+	}
+	if p.Meta.ISO != "" {
+		info = append(info, "ISO "+p.Meta.ISO)
+	}
+	if f, err := strconv.ParseFloat(p.Meta.FocalLength, 64); err == nil && f > 0 {
+		info = append(info, fmt.Sprintf("%g mm", math.Round(f)))
+	}
+	return [2]string{name, fitText(strings.Join(info, "  "), width, measure)}
+}
+
+// fitText shortens text, so that it fits in width.
+func fitText(text string, width float64, measure func(string) float64) string {
+	if measure(text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if res := string(runes) + "..."; measure(res) <= width {
+			return res
+		}
+	}
+	return ""
+}
+
+func contactSheetPDF(title string, photos []sheetPhoto, settings sheetSettings) ([]byte, error) {
+	pageWidth, pageHeight := settings.pageSize()
+	_, _, box := settings.cellSize()
+	perPage := settings.rows() * settings.columns()
+	pages := max(1, (len(photos)+perPage-1)/perPage)
+
+	measure := func(s string) float64 { return pdf.TextWidth(s, sheetCaption) }
+
+	var doc pdf.Document
+	for n := 0; n < pages; n++ {
+		page := doc.AddPage(pageWidth, pageHeight)
+
+		number := fmt.Sprintf("%d / %d", n+1, pages)
+		heading := fitText(title, pageWidth/2, func(s string) float64 { return pdf.TextWidth(s, 12) })
+		page.Text(sheetMargin, pageHeight-sheetMargin-12, 12, heading)
+		page.Text(pageWidth-sheetMargin-pdf.TextWidth(number, 10), pageHeight-sheetMargin-12, 10, number)
+
+		for i := 0; i < perPage && n*perPage+i < len(photos); i++ {
+			photo := &photos[n*perPage+i]
+			x, y := settings.cellOrigin(i)
+			dx, dy, width, height := fitThumb(photo.Thumb, box)
+			if err := page.Image(photo.JPEG, x+dx, pageHeight-(y+dy+height), width, height); err != nil {
+				return nil, err
+			}
+			for l, caption := range photo.captions(box, measure) {
+				baseline := y + box + sheetGap/2 + float64(l+1)*sheetLeading
+				page.Text(x, pageHeight-baseline, sheetCaption, caption)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func contactSheetJPEG(title string, photos []sheetPhoto, settings sheetSettings) ([]byte, error) {
+	pageWidth, _ := settings.pageSize()
+	_, cellHeight, box := settings.cellSize()
+	rows := max(1, (len(photos)+settings.columns()-1)/settings.columns())
+	pageHeight := 2*sheetMargin + sheetHeader + float64(rows)*cellHeight
+
+	img := image.NewRGBA(image.Rect(0, 0, int(pageWidth*sheetScale), int(pageHeight*sheetScale)))
+	draw.Draw(img, img.Rect, image.White, image.Point{}, draw.Src)
+
+	fnt, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	newDrawer := func(size float64) (*font.Drawer, error) {
+		face, err := opentype.NewFace(fnt, &opentype.FaceOptions{Size: size * sheetScale, DPI: 72})
+		if err != nil {
+			return nil, err
+		}
+		return &font.Drawer{Dst: img, Src: image.Black, Face: face}, nil
+	}
+	text := func(drawer *font.Drawer, x, y float64, s string) {
+		drawer.Dot = fixed.P(int(x*sheetScale), int(y*sheetScale))
+		drawer.DrawString(s)
+	}
+
+	heading, err := newDrawer(12)
+	if err != nil {
+		return nil, err
+	}
+	defer heading.Face.Close()
+	caption, err := newDrawer(sheetCaption)
+	if err != nil {
+		return nil, err
+	}
+	defer caption.Face.Close()
+
+	// measure in points
+	measure := func(drawer *font.Drawer) func(string) float64 {
+		return func(s string) float64 { return float64(drawer.MeasureString(s)) / 64 / sheetScale }
+	}
+
+	text(heading, sheetMargin, sheetMargin+12, fitText(title, pageWidth-2*sheetMargin, measure(heading)))
+	for i := range photos {
+		photo := &photos[i]
+		x, y := settings.cellOrigin(i)
+		dx, dy, width, height := fitThumb(photo.Thumb, box)
+		rect := image.Rect(
+			int((x+dx)*sheetScale), int((y+dy)*sheetScale),
+			int((x+dx+width)*sheetScale), int((y+dy+height)*sheetScale))
+		draw.CatmullRom.Scale(img, rect, photo.Thumb, photo.Thumb.Bounds(), draw.Src, nil)
+
+		for l, line := range photo.captions(box, measure(caption)) {
+			baseline := y + box + sheetGap/2 + float64(l+1)*sheetLeading
+			text(caption, x, baseline, line)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func Test_sheetPhoto_captions(t *testing.T) {
+	photo := sheetPhoto{
+		Name:   "IMG_0001.CR2",
+		Rating: 3,
+		Meta:   photoMeta{ExposureTime: "0.004", FNumber: "2.8", ISO: "200", FocalLength: "50.0"},
+	}
+	got := photo.captions(1000, func(s string) float64 { return float64(len(s)) })
+	if got[0] != "IMG_0001.CR2  ***" {
+		t.Errorf("captions()[0] = %q", got[0])
+	}
+	if got[1] != "1/250 s  f/2.8  ISO 200  50 mm" {
+		t.Errorf("captions()[1] = %q", got[1])
+	}
+
+	got = photo.captions(12, func(s string) float64 { return float64(len(s)) })
+	if got[0] != "IMG_...  ***" {
+		t.Errorf("captions()[0] = %q", got[0])
+	}
+}
+
+func Test_contactSheet(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 60, 40))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := sheetSettings{Columns: 4, Paper: "a4"}
+	photos := make([]sheetPhoto, settings.rows()*settings.columns()+1)
+	for i := range photos {
+		photos[i] = sheetPhoto{Name: "photo", Thumb: img, JPEG: buf.Bytes()}
+	}
+
+	data, err := contactSheetPDF("Title", photos, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("/Type /Page ")); n != 2 {
+		t.Errorf("contactSheetPDF() has %d pages, want 2", n)
+	}
+
+	data, err = contactSheetJPEG("Title", photos, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+	}
+}
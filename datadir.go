@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ncruces/rethinkraw/internal/config"
+)
+
+// Named settings (watermarks, presets, etc) are stored as
+// JSON files in a subdirectory of the data directory.
+
+func dataPath(dir, name string) (string, error) {
+	if !validName(name) {
+		return "", errors.New("invalid name: " + strconv.Quote(name))
+	}
+	return filepath.Join(config.DataDir, dir, name+".json"), nil
+}
+
+// validName reports whether name can be safely used as a file name.
+func validName(name string) bool {
+	return name != "" && len(name) <= 128 &&
+		!strings.HasPrefix(name, ".") &&
+		!strings.ContainsAny(name, `/\:*?"<>|`+"\x00")
+}
+
+func listData(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(config.DataDir, dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasSuffix(name, ".json") && entry.Type().IsRegular() {
+			names = append(names, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func loadData(dir, name string, v any) error {
+	path, err := dataPath(dir, name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func saveData(dir, name string, v any) error {
+	path, err := dataPath(dir, name)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes a file through a temporary file in the same directory,
+// so that a crash never leaves it truncated.
+func writeFileAtomic(path string, data []byte) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func deleteData(dir, name string) error {
+	path, err := dataPath(dir, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Descriptive (IPTC Core) metadata, stored in the XMP sidecar.
+type xmpDescription struct {
+	Title      string   `json:"title"`
+	Caption    string   `json:"caption"`
+	Keywords   []string `json:"keywords"`
+	Creator    string   `json:"creator"`
+	Copyright  string   `json:"copyright"`
+	UsageTerms string   `json:"usageTerms"`
+
+	Location    string `json:"location"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"`
+
+	ContactEmail   string `json:"contactEmail"`
+	ContactPhone   string `json:"contactPhone"`
+	ContactURL     string `json:"contactURL"`
+	ContactAddress string `json:"contactAddress"`
+	ContactCity    string `json:"contactCity"`
+	ContactCountry string `json:"contactCountry"`
+}
+
+type xmpDescriptionTag struct {
+	name string    // form/JSON name
+	tag  string    // exiftool tag
+	val  *string   // either a value
+	list *[]string // or a list
+}
+
+func (d *xmpDescription) tags() []xmpDescriptionTag {
+	return []xmpDescriptionTag{
+		{name: "title", tag: "XMP-dc:Title", val: &d.Title},
+		{name: "caption", tag: "XMP-dc:Description", val: &d.Caption},
+		{name: "keywords", tag: "XMP-dc:Subject", list: &d.Keywords},
+		{name: "creator", tag: "XMP-dc:Creator", val: &d.Creator},
+		{name: "copyright", tag: "XMP-dc:Rights", val: &d.Copyright},
+		{name: "usageTerms", tag: "XMP-xmpRights:UsageTerms", val: &d.UsageTerms},
+		{name: "location", tag: "XMP-iptcCore:Location", val: &d.Location},
+		{name: "city", tag: "XMP-photoshop:City", val: &d.City},
+		{name: "state", tag: "XMP-photoshop:State", val: &d.State},
+		{name: "country", tag: "XMP-photoshop:Country", val: &d.Country},
+		{name: "countryCode", tag: "XMP-iptcCore:CountryCode", val: &d.CountryCode},
+		{name: "contactEmail", tag: "XMP-iptcCore:CreatorWorkEmail", val: &d.ContactEmail},
+		{name: "contactPhone", tag: "XMP-iptcCore:CreatorWorkTelephone", val: &d.ContactPhone},
+		{name: "contactURL", tag: "XMP-iptcCore:CreatorWorkURL", val: &d.ContactURL},
+		{name: "contactAddress", tag: "XMP-iptcCore:CreatorAddress", val: &d.ContactAddress},
+		{name: "contactCity", tag: "XMP-iptcCore:CreatorCity", val: &d.ContactCity},
+		{name: "contactCountry", tag: "XMP-iptcCore:CreatorCountry", val: &d.ContactCountry},
+	}
+}
+
+// parseDescription parses a description from a form.
+// Only fields present in the form are edited; other fields are kept.
+// Keywords are either repeated, or comma separated.
+func parseDescription(form url.Values) (desc xmpDescription, fields []string) {
+	for _, t := range desc.tags() {
+		vs, ok := lookupFold(form, t.name)
+		if !ok {
+			continue
+		}
+		fields = append(fields, t.name)
+		if t.list != nil {
+			*t.list = []string{}
+			for _, v := range vs {
+				for _, v := range strings.Split(v, ",") {
+					if v := strings.TrimSpace(v); v != "" {
+						*t.list = append(*t.list, v)
+					}
+				}
+			}
+		} else if len(vs) > 0 {
+			*t.val = strings.TrimSpace(vs[0])
+		}
+	}
+	return desc, fields
+}
+
+// lookupFold finds a form key case insensitively, like gorilla/schema.
+func lookupFold(form url.Values, key string) ([]string, bool) {
+	for k, vs := range form {
+		if strings.EqualFold(k, key) {
+			return vs, true
+		}
+	}
+	return nil, false
+}
+
+func loadXMPDescription(ctx context.Context, path string) (desc xmpDescription, err error) {
+	args := []string{"-json", "-groupNames1", "-fast2"}
+	for _, t := range desc.tags() {
+		args = append(args, "-"+t.tag)
+	}
+	args = append(args, path)
+
+	log.Print("exiftool (load description)...")
+	out, err := exifCommand(ctx, args...)
+	if err != nil {
+		return desc, err
+	}
+
+	var res []map[string]any
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	if err := dec.Decode(&res); err != nil {
+		return desc, err
+	}
+	if len(res) != 1 {
+		return desc, errors.New("unexpected exiftool output")
+	}
+
+	for _, t := range desc.tags() {
+		vs := jsonStrings(res[0][t.tag])
+		if t.list != nil {
+			*t.list = vs
+		} else if len(vs) > 0 {
+			*t.val = strings.Join(vs, "; ")
+		}
+	}
+	if desc.Keywords == nil {
+		desc.Keywords = []string{}
+	}
+	return desc, nil
+}
+
+// jsonStrings converts an exiftool JSON value (a scalar or a list) into strings.
+func jsonStrings(v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		var res []string
+		for _, v := range v {
+			res = append(res, jsonStrings(v)...)
+		}
+		return res
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// editXMPDescription edits the given fields of a description.
+func editXMPDescription(ctx context.Context, path string, desc xmpDescription, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// -E unescapes HTML entities, so values can have newlines
+	opts := []string{"-escapeHTML"}
+	for _, t := range desc.tags() {
+		if !contains(fields, t.name) {
+			continue
+		}
+		if t.list != nil {
+			opts = append(opts, "-"+t.tag+"=")
+			for _, v := range *t.list {
+				opts = append(opts, "-"+t.tag+"+="+escapeValue(v))
+			}
+		} else {
+			opts = append(opts, "-"+t.tag+"="+escapeValue(*t.val))
+		}
+	}
+	opts = append(opts, "-overwrite_original", path)
+
+	log.Print("exiftool (edit description)...")
+	_, err := exifCommand(ctx, opts...)
+	return err
+}
+
+// changes reports whether editing the given fields would change the old description.
+func (d *xmpDescription) changes(old *xmpDescription, fields []string) bool {
+	tags, olds := d.tags(), old.tags()
+	for i, t := range tags {
+		if !contains(fields, t.name) {
+			continue
+		}
+		if t.list != nil {
+			if len(*t.list) != len(*olds[i].list) {
+				return true
+			}
+			for j, v := range *t.list {
+				if v != (*olds[i].list)[j] {
+					return true
+				}
+			}
+		} else if *t.val != *olds[i].val {
+			return true
+		}
+	}
+	return false
+}
+
+func escapeValue(s string) string {
+	s = html.EscapeString(s)
+	return strings.NewReplacer("\r", "&#xd;", "\n", "&#xa;").Replace(s)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func loadDescription(ctx context.Context, path string) (xmpDescription, error) {
+	wk, err := openWorkspace(ctx, path)
+	if err != nil {
+		return xmpDescription{}, err
+	}
+	defer wk.close()
+
+	return loadXMPDescription(ctx, wk.origXMP())
+}
+
+// saveDescription edits the given fields of a photo's description.
+// Photos whose description wouldn't change are left alone,
+// so their sidecars (or DNGs) aren't rewritten.
+func saveDescription(ctx context.Context, path string, desc xmpDescription, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	wk, err := openWorkspace(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer wk.close()
+
+	old, err := loadXMPDescription(ctx, wk.origXMP())
+	if err != nil {
+		return err
+	}
+	if !desc.changes(&old, fields) {
+		return nil
+	}
+
+	err = editXMPDescription(ctx, wk.origXMP(), desc, fields)
+	if err != nil {
+		return err
+	}
+	return saveSidecar(ctx, &wk, path)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func Test_parseDescription(t *testing.T) {
+	form := url.Values{
+		"description": {""},
+		"Title":       {" Sunset "},
+		"keywords":    {"beach, sea", "sky,,"},
+		"copyright":   {""},
+	}
+
+	desc, fields := parseDescription(form)
+	if want := []string{"title", "keywords", "copyright"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("parseDescription() fields = %v, want %v", fields, want)
+	}
+	if desc.Title != "Sunset" {
+		t.Errorf("parseDescription() title = %q, want %q", desc.Title, "Sunset")
+	}
+	if want := []string{"beach", "sea", "sky"}; !reflect.DeepEqual(desc.Keywords, want) {
+		t.Errorf("parseDescription() keywords = %q, want %q", desc.Keywords, want)
+	}
+}
+
+func Test_xmpDescription_changes(t *testing.T) {
+	old := xmpDescription{Title: "Sunset", Keywords: []string{"beach", "sea"}}
+	tests := []struct {
+		name   string
+		desc   xmpDescription
+		fields []string
+		want   bool
+	}{
+		{"no fields", xmpDescription{Title: "Sunrise"}, nil, false},
+		{"same title", xmpDescription{Title: "Sunset"}, []string{"title"}, false},
+		{"new title", xmpDescription{Title: "Sunrise"}, []string{"title"}, true},
+		{"unedited title", xmpDescription{Keywords: []string{"beach", "sea"}}, []string{"keywords"}, false},
+		{"clear title", xmpDescription{}, []string{"title"}, true},
+		{"new keyword", xmpDescription{Keywords: []string{"beach", "sky"}}, []string{"keywords"}, true},
+		{"fewer keywords", xmpDescription{Keywords: []string{"beach"}}, []string{"keywords"}, true},
+		{"empty caption", xmpDescription{}, []string{"caption"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.desc.changes(&old, tt.fields); got != tt.want {
+				t.Errorf("changes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
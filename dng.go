@@ -2,26 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os/exec"
+	"regexp"
+	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/ncruces/rethinkraw/pkg/dngconv"
-	"golang.org/x/sync/semaphore"
+	"github.com/ncruces/rethinkraw/pkg/osutil"
 )
 
-var semDNGConverter = semaphore.NewWeighted(3)
-
 func runDNGConverter(ctx context.Context, input, output string, side int, exp *exportSettings) error {
 	args := []string{}
 	if exp != nil && exp.DNG {
-		if exp.Preview != "" {
-			args = append(args, "-"+exp.Preview)
-		}
-		if exp.Lossy {
-			args = append(args, "-lossy")
+		var err error
+		args, err = exp.dngArgs()
+		if err != nil {
+			return err
 		}
-		if exp.Embed {
-			args = append(args, "-e")
+		// if the version is unknown, let the converter decide
+		if ver, verr := dngconv.Version(); verr == nil {
+			err = exp.checkConverterVersion(ver)
+			if err != nil {
+				return err
+			}
 		}
 	} else {
 		if side > 0 {
@@ -30,11 +37,188 @@ func runDNGConverter(ctx context.Context, input, output string, side int, exp *e
 		args = append(args, "-p2")
 	}
 
-	if err := semDNGConverter.Acquire(ctx, 1); err != nil {
+	if err := schedDNGConverter.acquire(ctx); err != nil {
 		return err
 	}
-	defer semDNGConverter.Release(1)
+	defer schedDNGConverter.release(ctx)
+
+	if batchOf(ctx) != "" {
+		ctx = dngconv.WithPriority(ctx, osutil.BelowNormal)
+	}
 
 	log.Print("dng converter...")
-	return dngconv.Convert(ctx, input, output, args...)
+	err := dngconv.Convert(ctx, input, output, args...)
+	if err != nil && ctx.Err() == nil && transientConverterError(err) {
+		return transientError{err}
+	}
+	return err
+}
+
+// transientConverterError reports whether a DNG converter failure may go away
+// if retried: the converter (or Wine) failing to start, or being killed
+// (e.g. when out of memory).
+// The converter exiting with an error (e.g. on a bad file) is permanent.
+func transientConverterError(err error) bool {
+	var serr *dngconv.StartError
+	if errors.As(err, &serr) {
+		return true
+	}
+	var eerr *exec.ExitError
+	if errors.As(err, &eerr) {
+		if eerr.ExitCode() < 0 {
+			return true // killed by a signal
+		}
+		if runtime.GOOS == "windows" {
+			switch uint32(eerr.ExitCode()) {
+			case 0xc0000017, // STATUS_NO_MEMORY
+				0xc000012d, // STATUS_COMMITMENT_LIMIT
+				0xc0000142: // STATUS_DLL_INIT_FAILED (e.g. out of desktop heap)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transientError is an error that may go away if the operation is retried.
+type transientError struct{ error }
+
+func (e transientError) Unwrap() error { return e.error }
+
+func isTransient(err error) bool {
+	var terr transientError
+	return errors.As(err, &terr)
+}
+
+var dngCompatRE = regexp.MustCompile(`^(?:cr(\d+)\.\d+|dng1\.(\d+)(?:\.\d+)?)$`)
+
+// dngArgs returns the DNG converter arguments for a DNG export.
+//
+// Compatibility is either a Camera Raw version (cr15.3),
+// or a DNG backward version (dng1.7).
+// JPEG XL compression requires DNG 1.7 (Camera Raw 15.3) or later,
+// and a converter that supports it.
+func (ex *exportSettings) dngArgs() ([]string, error) {
+	var args []string
+
+	switch ex.Preview {
+	case "":
+	case "p0", "p1", "p2":
+		args = append(args, "-"+ex.Preview)
+	default:
+		return nil, errors.New("invalid DNG preview: " + strconv.Quote(ex.Preview))
+	}
+
+	if ex.Compat != "" {
+		m := dngCompatRE.FindStringSubmatch(ex.Compat)
+		if m == nil {
+			return nil, errors.New("invalid DNG compatibility: " + strconv.Quote(ex.Compat))
+		}
+		if ex.Compression == "jxl" {
+			cr, _ := strconv.Atoi(m[1])
+			dng, _ := strconv.Atoi(m[2])
+			if m[1] != "" && cr < 15 || m[2] != "" && dng < 7 {
+				return nil, errors.New("JPEG XL compression requires DNG 1.7 compatibility")
+			}
+		}
+		args = append(args, "-"+ex.Compat)
+	}
+
+	switch ex.Compression {
+	case "":
+	case "uncompressed":
+		if ex.Lossy {
+			return nil, errors.New("lossy DNGs can't be uncompressed")
+		}
+		args = append(args, "-u")
+	case "jxl":
+		args = append(args, "-jxl")
+	default:
+		return nil, errors.New("invalid DNG compression: " + strconv.Quote(ex.Compression))
+	}
+
+	if ex.Lossy {
+		args = append(args, "-lossy")
+		if ex.LossySide > 0 {
+			args = append(args, "-side", strconv.Itoa(ex.LossySide))
+		}
+		if ex.LossyMPixels > 0 {
+			args = append(args, "-count", strconv.Itoa(int(ex.LossyMPixels*1e6)))
+		}
+	}
+	if ex.FastLoad {
+		args = append(args, "-fl")
+	}
+	if ex.Embed {
+		args = append(args, "-e")
+	}
+	return args, nil
+}
+
+// dngBackwardVersions maps DNG backward versions
+// to the first DNG converter version that writes them.
+var dngBackwardVersions = map[string]string{
+	"5": "12.4",
+	"6": "13.2",
+	"7": "15.3",
+}
+
+// checkConverterVersion checks that a DNG converter version
+// supports the options of a DNG export.
+func (ex *exportSettings) checkConverterVersion(ver string) error {
+	check := func(opt, want string) error {
+		if compareVersions(ver, want) < 0 {
+			return fmt.Errorf("%s requires Adobe DNG Converter %s or later (installed: %s)", opt, want, ver)
+		}
+		return nil
+	}
+
+	if m := dngCompatRE.FindStringSubmatch(ex.Compat); m != nil {
+		want := dngBackwardVersions[m[2]]
+		if m[1] != "" {
+			want = ex.Compat[len("cr"):]
+		} else if ex.Compat == "dng1.7.1" {
+			want = "16.0"
+		}
+		if err := check("DNG compatibility "+strconv.Quote(ex.Compat), want); err != nil {
+			return err
+		}
+	}
+	if ex.Compression == "jxl" {
+		if err := check("JPEG XL compression", "15.3"); err != nil {
+			return err
+		}
+	}
+	if ex.Lossy && (ex.LossySide > 0 || ex.LossyMPixels > 0) {
+		if err := check("lossy DNG size limits", "7.1"); err != nil {
+			return err
+		}
+	}
+	if ex.FastLoad {
+		if err := check("fast load data", "7.1"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareVersions compares dotted version numbers, missing parts count as zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return +1
+		}
+	}
+	return 0
 }
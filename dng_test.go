@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/ncruces/rethinkraw/pkg/dngconv"
+)
+
+func Test_exportSettings_dngArgs(t *testing.T) {
+	tests := []struct {
+		exp     exportSettings
+		want    []string
+		wantErr bool
+	}{
+		{exportSettings{Preview: "p1"}, []string{"-p1"}, false},
+		{exportSettings{Preview: "x"}, nil, true},
+		{exportSettings{Compat: "cr15.3", Compression: "jxl"}, []string{"-cr15.3", "-jxl"}, false},
+		{exportSettings{Compat: "dng1.7.1", FastLoad: true}, []string{"-dng1.7.1", "-fl"}, false},
+		{exportSettings{Compat: "dng1.4", Compression: "jxl"}, nil, true},
+		{exportSettings{Compat: "cr14.0", Compression: "jxl"}, nil, true},
+		{exportSettings{Compat: "-d /tmp"}, nil, true},
+		{exportSettings{Compression: "uncompressed", Embed: true}, []string{"-u", "-e"}, false},
+		{exportSettings{Compression: "uncompressed", Lossy: true}, nil, true},
+		{exportSettings{Lossy: true, LossySide: 2048, LossyMPixels: 4}, []string{"-lossy", "-side", "2048", "-count", "4000000"}, false},
+		{exportSettings{LossySide: 2048}, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := tt.exp.dngArgs()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("dngArgs(%+v) error = %v", tt.exp, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dngArgs(%+v) = %q, want %q", tt.exp, got, tt.want)
+		}
+	}
+}
+
+func Test_exportSettings_checkConverterVersion(t *testing.T) {
+	tests := []struct {
+		exp     exportSettings
+		ver     string
+		wantErr bool
+	}{
+		{exportSettings{}, "6.0", false},
+		{exportSettings{Compat: "cr15.3", Compression: "jxl"}, "16.0.0.123", false},
+		{exportSettings{Compat: "cr15.3"}, "15.2.0.1", true},
+		{exportSettings{Compat: "dng1.4"}, "7.1", false},
+		{exportSettings{Compat: "dng1.7"}, "14.5", true},
+		{exportSettings{Compat: "dng1.7.1"}, "15.5", true},
+		{exportSettings{Compat: "dng1.7.1"}, "16.0", false},
+		{exportSettings{Compression: "jxl"}, "15.0", true},
+		{exportSettings{Lossy: true, LossySide: 2048}, "7.0", true},
+		{exportSettings{Lossy: true}, "7.0", false},
+		{exportSettings{FastLoad: true}, "6.6", true},
+		{exportSettings{FastLoad: true}, "7.1.0.5", false},
+	}
+	for _, tt := range tests {
+		err := tt.exp.checkConverterVersion(tt.ver)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkConverterVersion(%+v, %q) error = %v", tt.exp, tt.ver, err)
+		}
+	}
+}
+
+func Test_compareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"16.0", "16.0.0.123", -1},
+		{"16.0.0", "16.0", 0},
+		{"15.3", "15.10", -1},
+		{"16.0", "15.3", +1},
+		{"7.1", "", +1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func Test_transientConverterError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	exit := func(script string) error {
+		return fmt.Errorf("dng converter: %w", exec.Command("sh", "-c", script).Run())
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"exit code", exit("exit 1"), false},
+		{"signal", exit("kill -9 $$"), true},
+		{"wine", &dngconv.StartError{Err: errors.New("winepath failed")}, true},
+		{"other", errors.New("file not found"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transientConverterError(tt.err); got != tt.want {
+				t.Errorf("transientConverterError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"image"
 	"io/fs"
 	"math"
@@ -15,33 +16,38 @@ import (
 	"github.com/ncruces/rethinkraw/pkg/xmp"
 )
 
-func loadEdit(path string) (xmp xmpSettings, err error) {
-	wk, err := openWorkspace(path)
+func loadEdit(ctx context.Context, path string) (xmp xmpSettings, err error) {
+	wk, err := openWorkspace(ctx, path)
 	if err != nil {
 		return xmp, err
 	}
 	defer wk.close()
 
-	return loadXMP(wk.origXMP())
+	return loadXMP(ctx, wk.origXMP())
 }
 
 func saveEdit(ctx context.Context, path string, xmp xmpSettings) error {
-	wk, err := openWorkspace(path)
+	wk, err := openWorkspace(ctx, path)
 	if err != nil {
 		return err
 	}
 	defer wk.close()
 
 	if xmp.WhiteBalance == "Camera Matching…" {
-		xmp.WhiteBalance = cameraMatchingWhiteBalance(wk.orig())
+		xmp.WhiteBalance = cameraMatchingWhiteBalance(ctx, wk.orig())
 	}
 
-	err = editXMP(wk.origXMP(), xmp)
+	err = editXMP(ctx, wk.origXMP(), xmp)
 	if err != nil {
 		return err
 	}
+	return saveSidecar(ctx, &wk, path)
+}
 
-	dest, err := destSidecar(path)
+// saveSidecar saves the workspace sidecar for path,
+// either next to it, or into the DNG itself.
+func saveSidecar(ctx context.Context, wk *workspace, path string) error {
+	dest, err := destSidecar(ctx, path)
 	if err != nil {
 		return err
 	}
@@ -69,22 +75,30 @@ func saveEdit(ctx context.Context, path string, xmp xmpSettings) error {
 	return os.Rename(dest+".bak", dest)
 }
 
-func previewEdit(ctx context.Context, path string, size int, xmp xmpSettings) ([]byte, error) {
-	wk, err := openWorkspace(path)
+func previewEdit(ctx context.Context, path string, size int, clipping bool, xmp xmpSettings) ([]byte, error) {
+	data, err := renderEdit(ctx, path, size, xmp)
+	if err != nil || !clipping {
+		return data, err
+	}
+	return clippingJPEG(data)
+}
+
+func renderEdit(ctx context.Context, path string, size int, xmp xmpSettings) ([]byte, error) {
+	wk, err := openWorkspace(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 	defer wk.close()
 
 	if xmp.WhiteBalance == "Camera Matching…" {
-		xmp.WhiteBalance = cameraMatchingWhiteBalance(wk.orig())
+		xmp.WhiteBalance = cameraMatchingWhiteBalance(ctx, wk.orig())
 	}
 
 	if size == 0 {
 		// log.Print("a")
 		// use the original RAW file for a full resolution preview
 
-		err = editXMP(wk.origXMP(), xmp)
+		err = editXMP(ctx, wk.origXMP(), xmp)
 		if err != nil {
 			return nil, err
 		}
@@ -94,7 +108,7 @@ func previewEdit(ctx context.Context, path string, size int, xmp xmpSettings) ([
 			return nil, err
 		}
 
-		err = editXMP(wk.temp(), xmp)
+		err = editXMP(ctx, wk.temp(), xmp)
 		if err != nil {
 			return nil, err
 		}
@@ -104,7 +118,7 @@ func previewEdit(ctx context.Context, path string, size int, xmp xmpSettings) ([
 		// log.Print("b")
 		// use edit.dng (downscaled to at most 2560 on the widest side)
 
-		err = editXMP(wk.edit(), xmp)
+		err = editXMP(ctx, wk.edit(), xmp)
 		if err != nil {
 			return nil, err
 		}
@@ -114,7 +128,7 @@ func previewEdit(ctx context.Context, path string, size int, xmp xmpSettings) ([
 			return nil, err
 		}
 
-		err = editXMP(wk.temp(), xmp)
+		err = editXMP(ctx, wk.temp(), xmp)
 		if err != nil {
 			return nil, err
 		}
@@ -129,7 +143,7 @@ func previewEdit(ctx context.Context, path string, size int, xmp xmpSettings) ([
 		// log.Print("c")
 		// create edit.dng (downscaled to 2560 on the widest side)
 
-		err = editXMP(wk.origXMP(), xmp)
+		err = editXMP(ctx, wk.origXMP(), xmp)
 		if err != nil {
 			return nil, err
 		}
@@ -139,7 +153,7 @@ func previewEdit(ctx context.Context, path string, size int, xmp xmpSettings) ([
 			return nil, err
 		}
 
-		err = editXMP(wk.edit(), xmp)
+		err = editXMP(ctx, wk.edit(), xmp)
 		if err != nil {
 			return nil, err
 		}
@@ -149,150 +163,336 @@ func previewEdit(ctx context.Context, path string, size int, xmp xmpSettings) ([
 }
 
 func exportEdit(ctx context.Context, path string, xmp xmpSettings, exp exportSettings) ([]byte, error) {
-	wk, err := openWorkspace(path)
+	out, err := exportEdits(ctx, path, xmp, []exportSettings{exp})
 	if err != nil {
 		return nil, err
 	}
+	return out[0].Data, nil
+}
 
-	if xmp.WhiteBalance == "Camera Matching…" {
-		xmp.WhiteBalance = cameraMatchingWhiteBalance(wk.orig())
-	}
+// exportOutput is the result of exporting a recipe.
+type exportOutput struct {
+	Data    []byte
+	Quality *int // the JPEG quality chosen to fit a maximum file size
+}
 
-	err = editXMP(wk.origXMP(), xmp)
+// exportEdits exports path once for each recipe.
+// JPEG, PNG and TIFF recipes share a single full resolution render.
+func exportEdits(ctx context.Context, path string, xmp xmpSettings, recipes []exportSettings) ([]exportOutput, error) {
+	wk, err := openWorkspace(ctx, path)
 	if err != nil {
 		return nil, err
 	}
+	defer wk.close()
+
+	if xmp.WhiteBalance == "Camera Matching…" {
+		xmp.WhiteBalance = cameraMatchingWhiteBalance(ctx, wk.orig())
+	}
 
-	dest, err := destSidecar(path)
+	err = editXMP(ctx, wk.origXMP(), xmp)
 	if err != nil {
 		return nil, err
 	}
 
-	err = osutil.Copy(wk.origXMP(), dest)
+	var rendered bool
+	render := func() error {
+		if rendered {
+			return nil
+		}
+
+		// convert twice, so that the full size preview is rendered with edits
+		err := runDNGConverter(ctx, wk.orig(), wk.temp(), 0, nil)
+		if err != nil {
+			return err
+		}
+		err = editXMP(ctx, wk.temp(), xmp)
+		if err != nil {
+			return err
+		}
+		err = runDNGConverter(ctx, wk.temp(), wk.render(), 0, nil)
+		if err != nil {
+			return err
+		}
+		err = editXMP(ctx, wk.render(), xmp)
+		if err != nil {
+			return err
+		}
+
+		rendered = true
+		return nil
+	}
 
-	wk.close()
+	out := make([]exportOutput, len(recipes))
+	for i, exp := range recipes {
+		if err := exp.checkColorSpace(); err != nil {
+			return nil, err
+		}
+		if exp.DNG {
+			out[i].Data, err = exportEditDNG(ctx, &wk, path, xmp, exp)
+		} else if err = render(); err == nil {
+			switch {
+			case exp.TIFF:
+				out[i].Data, err = exportEditTIFF(ctx, &wk, xmp, exp)
+			case exp.PNG:
+				out[i].Data, err = exportEditPNG(ctx, &wk, exp)
+			default:
+				out[i], err = exportEditJPEG(ctx, &wk, exp)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
 
-	wk, err = openWorkspace(path)
+func exportEditDNG(ctx context.Context, wk *workspace, path string, xmp xmpSettings, exp exportSettings) ([]byte, error) {
+	err := runDNGConverter(ctx, wk.orig(), wk.temp(), 0, &exp)
 	if err != nil {
 		return nil, err
 	}
-	defer wk.close()
 
-	if xmp.WhiteBalance == "Camera Matching…" {
-		xmp.WhiteBalance = cameraMatchingWhiteBalance(wk.orig())
+	err = editXMP(ctx, wk.temp(), xmp)
+	if err != nil {
+		return nil, err
+	}
+	err = fixMetaDNG(ctx, wk.orig(), wk.temp(), path, exp.Metadata)
+	if err != nil {
+		return nil, err
 	}
 
-	err = editXMP(wk.origXMP(), xmp)
+	return os.ReadFile(wk.temp())
+}
+
+func exportEditTIFF(ctx context.Context, wk *workspace, xmp xmpSettings, exp exportSettings) ([]byte, error) {
+	// dcraw develops the full resolution DNG into a 16-bit TIFF
+	data, err := exportTIFF(ctx, wk.render(), xmp, exp)
 	if err != nil {
 		return nil, err
 	}
 
-	err = runDNGConverter(ctx, wk.orig(), wk.edit(), 0, &exp)
+	err = os.WriteFile(wk.tiff(), data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = fixMeta(ctx, wk.render(), wk.tiff(), exp.Metadata, false)
+	if err != nil {
+		return nil, err
+	}
+	err = resetOrientation(ctx, wk.tiff())
+	if err != nil {
+		return nil, err
+	}
+	err = embedICC(ctx, wk.tiff(), exp.colorSpace(), wk.icc())
 	if err != nil {
 		return nil, err
 	}
 
-	err = editXMP(wk.edit(), xmp)
+	return os.ReadFile(wk.tiff())
+}
+
+func exportEditPNG(ctx context.Context, wk *workspace, exp exportSettings) ([]byte, error) {
+	data, err := exportJPEG(ctx, wk.render())
+	if err != nil {
+		return nil, err
+	}
+	data, err = exportPNG(data, exp)
 	if err != nil {
 		return nil, err
 	}
 
-	err = runDNGConverter(ctx, wk.edit(), wk.temp(), 0, &exp)
+	err = os.WriteFile(wk.png(), data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = fixMeta(ctx, wk.render(), wk.png(), exp.Metadata, false)
+	if err != nil {
+		return nil, err
+	}
+	err = resetOrientation(ctx, wk.png())
 	if err != nil {
 		return nil, err
 	}
+	err = embedICC(ctx, wk.png(), exp.colorSpace(), wk.icc())
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(wk.png())
+}
+
+func exportEditJPEG(ctx context.Context, wk *workspace, exp exportSettings) (exportOutput, error) {
+	data, err := exportJPEG(ctx, wk.render())
+	if err != nil {
+		return exportOutput{}, err
+	}
+
+	if exp.MaxSize > 0 {
+		return exportSizedJPEG(ctx, wk, data, exp)
+	}
+
+	// resampling starts from the full size preview of the render
+	var reencode bool
+	if exp.Resample {
+		reencode = true
+		data, err = resampleJPEG(data, exp)
+		if err != nil {
+			return exportOutput{}, err
+		}
+	} else if exp.Watermark != "" || math.Abs(float64(jpegQuality(data)-jpegQualities[exp.quality()])) > 2 {
+		// the preview is kept as is, unless it's watermarked, or its quality is off
+		reencode = true
+		data, err = reencodeJPEG(data, exp)
+		if err != nil {
+			return exportOutput{}, err
+		}
+	}
+
+	data, err = finishJPEG(ctx, wk, data, reencode, exp)
+	return exportOutput{Data: data}, err
+}
+
+// exportSizedJPEG encodes the best quality JPEG under the maximum file size.
+// Since metadata is added after encoding, the image budget is reduced
+// by the measured metadata overhead, until the result fits.
+func exportSizedJPEG(ctx context.Context, wk *workspace, data []byte, exp exportSettings) (exportOutput, error) {
+	img, err := exportImage(data, exp)
+	if err != nil {
+		return exportOutput{}, err
+	}
+
+	max := int(exp.MaxSize * 1e6)
+	budget := max
+	for i := 0; i < 3; i++ {
+		data, quality, err := sizedJPEG(img, budget, exp)
+		if err != nil {
+			return exportOutput{}, err
+		}
+		out, err := finishJPEG(ctx, wk, data, true, exp)
+		if err != nil {
+			return exportOutput{}, err
+		}
+		if len(out) <= max {
+			return exportOutput{Data: out, Quality: &quality}, nil
+		}
+		budget -= len(out) - max
+	}
+	return exportOutput{}, errors.New("can't fit JPEG in the maximum file size")
+}
 
-	err = editXMP(wk.temp(), xmp)
+// finishJPEG adds metadata and an ICC profile to an exported JPEG.
+// If the JPEG was reencoded, orientation has been applied and is reset.
+func finishJPEG(ctx context.Context, wk *workspace, data []byte, reencoded bool, exp exportSettings) ([]byte, error) {
+	err := os.WriteFile(wk.jpeg(), data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = injectXMP(ctx, wk.render(), wk.jpeg())
+	if err != nil {
+		return nil, err
+	}
+	err = fixMeta(ctx, wk.jpeg(), wk.jpeg(), exp.Metadata, true)
 	if err != nil {
 		return nil, err
 	}
+	if reencoded {
+		err = resetOrientation(ctx, wk.jpeg())
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = embedICC(ctx, wk.jpeg(), exp.colorSpace(), wk.icc())
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(wk.jpeg())
+}
 
-	previewJPEG(ctx, wk.temp())
+// loadLuminance renders a preview of path and returns its median luminance.
+func loadLuminance(ctx context.Context, path string, xmp xmpSettings) (float64, error) {
+	data, err := renderEdit(ctx, path, 1024, xmp)
+	if err != nil {
+		return 0, err
+	}
+	return medianLuminance(data)
+}
 
-	data, err := exportJPEG(ctx, wk.temp())
-	err = os.WriteFile(wk.jpeg(), data, 0600)
+// exposureMatch reports how the exposure of a photo was matched.
+type exposureMatch struct {
+	Exposure    float32 `json:"exposure"`
+	AutoToneOff bool    `json:"autoToneOff,omitempty"` // auto tone overrides exposure, so it was turned off
+}
 
-	err = injectXMP(wk.temp(), wk.jpeg())
+// matchExposure adjusts the exposure of path so that its median luminance
+// approaches target, and saves the edit.
+func matchExposure(ctx context.Context, path string, target float64) (*exposureMatch, error) {
+	xmp, err := loadEdit(ctx, path)
 	if err != nil {
 		return nil, err
 	}
-	err = fixMetaJPEG(wk.jpeg(), wk.jpeg())
+	xmp.Filename = filepath.Base(path)
+
+	res, err := adjustExposure(&xmp, target, func(xmp xmpSettings) (float64, error) {
+		return loadLuminance(ctx, path, xmp)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &res, saveEdit(ctx, path, xmp)
+}
 
-	os.RemoveAll(dest)
+// adjustExposure adjusts exposure, until the measured luminance matches target.
+// Since tone curves are not linear, this takes a few iterations to converge.
+// Auto tone is turned off, since it would override exposure.
+func adjustExposure(xmp *xmpSettings, target float64, measure func(xmpSettings) (float64, error)) (exposureMatch, error) {
+	res := exposureMatch{AutoToneOff: xmp.AutoTone}
+	xmp.AutoTone = false
 
-	return os.ReadFile(wk.jpeg())
+	for i := 0; i < 3; i++ {
+		lum, err := measure(*xmp)
+		if err != nil {
+			return res, err
+		}
+		if lum <= 0 {
+			return res, errors.New("photo is completely black")
+		}
 
-	// data, err := previewJPEG(ctx, wk.edit())
-
-	// err = os.WriteFile(wk.jpeg(), data, 0600)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// return os.ReadFile(wk.jpeg())
-
-	// err = editXMP(wk.origXMP(), xmp)
-	// log.Print(wk.origXMP())
-	// log.Print(xmp)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// err = runDNGConverter(ctx, wk.orig(), wk.temp(), 0, &exp)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// err = editXMP(wk.temp(), xmp)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// if exp.DNG {
-	// 	err = fixMetaDNG(wk.orig(), wk.temp(), path)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	return os.ReadFile(wk.temp())
-	// } else {
-	// 	data, err := exportJPEG(ctx, wk.temp())
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	if exp.Resample {
-	// 		return resampleJPEG(data, exp)
-	// 	}
-
-	// 	err = os.WriteFile(wk.jpeg(), data, 0600)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	// err = injectXMP(wk.temp(), wk.jpeg())
-	// 	// if err != nil {
-	// 	// 	return nil, err
-	// 	// }
-	// 	// err = fixMetaJPEG(wk.orig(), wk.jpeg())
-	// 	// if err != nil {
-	// 	// 	return nil, err
-	// 	// }
-	// 	return os.ReadFile(wk.jpeg())
-	// }
+		delta := math.Log2(target / lum)
+		if math.Abs(delta) < 0.05 {
+			break
+		}
+
+		exp := float32(math.Max(-5, math.Min(+5, float64(xmp.Exposure)+delta)))
+		if exp == xmp.Exposure {
+			break
+		}
+		xmp.Exposure = exp
+	}
+
+	res.Exposure = xmp.Exposure
+	return res, nil
 }
 
 func exportPath(path string, exp exportSettings) string {
-	var ext string
-	if exp.DNG {
-		ext = ".dng"
-	} else {
-		ext = ".jpg"
+	return strings.TrimSuffix(path, filepath.Ext(path)) + exportExt(exp)
+}
+
+func exportExt(exp exportSettings) string {
+	switch {
+	case exp.DNG:
+		return ".dng"
+	case exp.TIFF:
+		return ".tif"
+	case exp.PNG:
+		return ".png"
+	default:
+		return ".jpg"
 	}
-	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
 }
 
 func loadWhiteBalance(ctx context.Context, path string, coords []float64) (wb xmpWhiteBalance, err error) {
-	wk, err := openWorkspace(path)
+	wk, err := openWorkspace(ctx, path)
 	if err != nil {
 		return wb, err
 	}
@@ -314,27 +514,97 @@ func loadWhiteBalance(ctx context.Context, path string, coords []float64) (wb xm
 		}
 	}
 
-	return computeWhiteBalance(wk.edit(), wk.pixels(), coords)
+	return computeWhiteBalance(ctx, wk.edit(), wk.pixels(), coords)
 }
 
 type exportSettings struct {
-	DNG     bool
-	Preview string
-	Lossy   bool
-	Embed   bool
-	Both    bool
-
-	Resample bool
-	Quality  int
-	Fit      string
-	Long     float64
-	Short    float64
-	Width    float64
-	Height   float64
-	DimUnit  string
-	Density  int
-	DenUnit  string
-	MPixels  float64
+	DNG     bool   `json:"dng,omitempty"`
+	TIFF    bool   `json:"tiff,omitempty"`
+	PNG     bool   `json:"png,omitempty"`
+	Preview string `json:"preview,omitempty"`
+	Lossy   bool   `json:"lossy,omitempty"`
+	Embed   bool   `json:"embed,omitempty"`
+
+	Compat       string  `json:"compat,omitempty"`      // cr15.3, dng1.7, etc
+	Compression  string  `json:"compression,omitempty"` // uncompressed or jxl
+	FastLoad     bool    `json:"fastLoad,omitempty"`
+	LossySide    int     `json:"lossySide,omitempty"`
+	LossyMPixels float64 `json:"lossyMPixels,omitempty"`
+
+	ColorSpace string `json:"colorSpace,omitempty"`
+	Watermark  string `json:"watermark,omitempty"`
+	Metadata   string `json:"metadata,omitempty"`
+
+	Template string `json:"template,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Folder   string `json:"folder,omitempty"`
+	Snapshot string `json:"-"` // preset name, for {snapshot}
+
+	Resample bool    `json:"resample,omitempty"`
+	Quality  int     `json:"quality,omitempty"` // 1-12, see quality()
+	Fit      string  `json:"fit,omitempty"`
+	Long     float64 `json:"long,omitempty"`
+	Short    float64 `json:"short,omitempty"`
+	Width    float64 `json:"width,omitempty"`
+	Height   float64 `json:"height,omitempty"`
+	DimUnit  string  `json:"dimUnit,omitempty"`
+	Density  int     `json:"density,omitempty"`
+	DenUnit  string  `json:"denUnit,omitempty"`
+	MPixels  float64 `json:"mpixels,omitempty"`
+	MaxSize  float64 `json:"maxSize,omitempty"` // MB
+
+	Sharpen       string `json:"sharpen,omitempty"`
+	SharpenAmount string `json:"sharpenAmount,omitempty"`
+}
+
+// validate checks settings that would otherwise only fail when exporting.
+func (ex *exportSettings) validate() error {
+	if _, ok := metaPolicies[ex.Metadata]; !ok && ex.Metadata != "" {
+		return fmt.Errorf("unknown metadata policy: %q", ex.Metadata)
+	}
+	if err := ex.checkColorSpace(); err != nil {
+		return err
+	}
+	if ex.DNG {
+		if _, err := ex.dngArgs(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkColorSpace checks that the color space is supported.
+// Camera Raw only renders sRGB, so only TIFF exports support wider color spaces.
+func (ex *exportSettings) checkColorSpace() error {
+	if _, ok := colorSpaces[ex.ColorSpace]; !ok && ex.ColorSpace != "" {
+		return fmt.Errorf("unsupported color space: %q", ex.ColorSpace)
+	}
+	if ex.colorSpace() != &srgbSpace && !ex.TIFF && !ex.DNG {
+		return fmt.Errorf("color space %q is only supported for TIFF exports", ex.ColorSpace)
+	}
+	return nil
+}
+
+// quality returns the JPEG quality, an index into jpegQualities.
+// If unset, it defaults to high quality.
+func (ex *exportSettings) quality() int {
+	if ex.Quality == 0 {
+		return 10
+	}
+	return ex.Quality
+}
+
+func (ex *exportSettings) colorSpace() *colorSpace {
+	if cs, ok := colorSpaces[ex.ColorSpace]; ok {
+		return cs
+	}
+	return &srgbSpace
+}
+
+// hasDensity reports whether the export was sized for print,
+// and should record its density.
+func (ex *exportSettings) hasDensity() bool {
+	return ex.Fit != "mpix" && ex.DimUnit != "px" && ex.Density > 0
 }
 
 func (ex *exportSettings) FitImage(size image.Point) (fit image.Point) {
@@ -400,7 +670,25 @@ func (ex *exportSettings) FitImage(size image.Point) (fit image.Point) {
 	return fit
 }
 
-func loadSidecar(src, dst string) error {
+func loadSidecar(ctx context.Context, src, dst string) error {
+	var data []byte
+	name, err := findSidecar(src)
+	if err == nil {
+		data, err = os.ReadFile(name)
+	}
+	if err == nil {
+		// copy xmp file
+		err = os.WriteFile(dst, data, 0600)
+	}
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		// extract embed XMP data
+		return extractXMP(ctx, src, dst)
+	}
+	return err
+}
+
+// findSidecar finds the existing sidecar for src.
+func findSidecar(src string) (string, error) {
 	var data []byte
 	err := os.ErrNotExist
 	ext := filepath.Ext(src)
@@ -409,29 +697,27 @@ func loadSidecar(src, dst string) error {
 		// if NAME.xmp is there for NAME.EXT, use it
 		name := strings.TrimSuffix(src, ext) + ".xmp"
 		data, err = os.ReadFile(name)
-		if err == nil && !xmp.IsSidecarForExt(bytes.NewReader(data), ext) {
+		if err == nil && xmp.IsSidecarForExt(bytes.NewReader(data), ext) {
+			return name, nil
+		}
+		if err == nil {
 			err = os.ErrNotExist
 		}
 	}
 	if errors.Is(err, fs.ErrNotExist) {
 		// if NAME.EXT.xmp is there for NAME.EXT, use it
 		data, err = os.ReadFile(src + ".xmp")
-		if err == nil && !xmp.IsSidecarForExt(bytes.NewReader(data), ext) {
+		if err == nil && xmp.IsSidecarForExt(bytes.NewReader(data), ext) {
+			return src + ".xmp", nil
+		}
+		if err == nil {
 			err = os.ErrNotExist
 		}
 	}
-	if err == nil {
-		// copy xmp file
-		err = os.WriteFile(dst, data, 0600)
-	}
-	if err == nil || errors.Is(err, fs.ErrNotExist) {
-		// extract embed XMP data
-		return extractXMP(src, dst)
-	}
-	return err
+	return "", err
 }
 
-func destSidecar(src string) (string, error) {
+func destSidecar(ctx context.Context, src string) (string, error) {
 	ext := filepath.Ext(src)
 
 	if ext != "" {
@@ -454,7 +740,7 @@ func destSidecar(src string) (string, error) {
 	}
 
 	// if NAME.DNG was edited, use it
-	if strings.EqualFold(ext, ".dng") && dngHasEdits(src) {
+	if strings.EqualFold(ext, ".dng") && dngHasEdits(ctx, src) {
 		return src, nil
 	}
 
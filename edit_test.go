@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_adjustExposure(t *testing.T) {
+	// a photo one stop darker than the target, with a slightly non-linear response
+	measure := func(xmp xmpSettings) (float64, error) {
+		return 0.09 * math.Pow(2, 0.9*float64(xmp.Exposure)), nil
+	}
+
+	tests := []struct {
+		name     string
+		xmp      xmpSettings
+		target   float64
+		want     float32
+		autoTone bool
+	}{
+		{"match", xmpSettings{}, 0.18, 1.1, false},
+		{"auto tone", xmpSettings{AutoTone: true}, 0.18, 1.1, true},
+		{"clamped", xmpSettings{}, 100, 5, false},
+		{"already matched", xmpSettings{Exposure: 1.1}, 0.18, 1.1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xmp := tt.xmp
+			res, err := adjustExposure(&xmp, tt.target, measure)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(float64(res.Exposure-tt.want)) > 0.05 || res.Exposure != xmp.Exposure {
+				t.Errorf("adjustExposure() exposure = %v (%v), want %v", res.Exposure, xmp.Exposure, tt.want)
+			}
+			if res.AutoToneOff != tt.autoTone || xmp.AutoTone {
+				t.Errorf("adjustExposure() autoToneOff = %v, want %v", res.AutoToneOff, tt.autoTone)
+			}
+		})
+	}
+
+	black := func(xmpSettings) (float64, error) { return 0, nil }
+	if _, err := adjustExposure(&xmpSettings{}, 0.18, black); err == nil {
+		t.Error("adjustExposure() should fail for a black photo")
+	}
+}
+
+func Test_exportSettings_checkColorSpace(t *testing.T) {
+	tests := []struct {
+		exp     exportSettings
+		wantErr bool
+	}{
+		{exportSettings{}, false},
+		{exportSettings{ColorSpace: "sRGB"}, false},
+		{exportSettings{ColorSpace: "AdobeRGB", TIFF: true}, false},
+		{exportSettings{ColorSpace: "DisplayP3", TIFF: true}, false},
+		{exportSettings{ColorSpace: "ProPhotoRGB", TIFF: true}, false},
+		{exportSettings{ColorSpace: "AdobeRGB"}, true},
+		{exportSettings{ColorSpace: "DisplayP3", PNG: true}, true},
+		{exportSettings{ColorSpace: "CMYK", TIFF: true}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.exp.checkColorSpace(); (err != nil) != tt.wantErr {
+			t.Errorf("checkColorSpace(%+v) = %v, wantErr %v", tt.exp, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export filename templates.
+//
+// Templates are file names (without extension) with tokens in braces,
+// some of which take an optional argument after a colon:
+//
+//	{name}          original file name, without extension
+//	{date:layout}   capture date (Go time layout, default 2006-01-02)
+//	{time:layout}   capture time (Go time layout, default 150405)
+//	{make}          camera make
+//	{camera}        camera model
+//	{lens}          lens model
+//	{iso}           ISO speed
+//	{seq:width}     sequence number in batch (zero padded, default width 4)
+//	{snapshot}      export preset name (empty if exported without a preset)
+//	{text}          custom text
+//
+// Slashes in the template (or in date/time layouts) create subfolders.
+
+// photoMeta is the metadata used to expand filename templates.
+type photoMeta struct {
+	Date  time.Time
+	Make  string
+	Model string
+	Lens  string
+	ISO   string
+
+	ExposureTime string
+	FNumber      string
+	FocalLength  string
+}
+
+func loadPhotoMeta(ctx context.Context, path string) (meta photoMeta, err error) {
+	log.Print("exiftool (get filename meta)...")
+	out, err := exifCommand(ctx, "-short3", "-forcePrint", "-fast", "-n",
+		"-DateTimeOriginal", "-Make", "-Model", "-LensModel", "-ISO",
+		"-ExposureTime", "-FNumber", "-FocalLength", path)
+	if err != nil {
+		return meta, err
+	}
+
+	var vals []string
+	for scan := bufio.NewScanner(bytes.NewReader(out)); scan.Scan(); {
+		val := strings.TrimSpace(scan.Text())
+		if val == "-" {
+			val = ""
+		}
+		vals = append(vals, val)
+	}
+	if len(vals) != 8 {
+		return meta, errors.New("unexpected exiftool output")
+	}
+
+	meta.Date, _ = time.Parse("2006:01:02 15:04:05", vals[0])
+	meta.Make = vals[1]
+	meta.Model = vals[2]
+	meta.Lens = vals[3]
+	meta.ISO = vals[4]
+	meta.ExposureTime = vals[5]
+	meta.FNumber = vals[6]
+	meta.FocalLength = vals[7]
+	return meta, nil
+}
+
+// templateNeedsMeta reports whether expanding a template requires reading metadata.
+func templateNeedsMeta(tmpl string) bool {
+	for _, tok := range []string{"{date", "{time", "{make", "{camera", "{lens", "{iso"} {
+		if strings.Contains(tmpl, tok) {
+			return true
+		}
+	}
+	return false
+}
+
+// exportName returns the name (relative, possibly with subfolders)
+// for the export of a photo, given its name relative to the batch,
+// and its sequence number.
+// Names are placed in the recipe's folder.
+func exportName(ctx context.Context, path, name string, seq int, exp exportSettings) (string, error) {
+	folder, err := relPath(filepath.ToSlash(exp.Folder))
+	if err != nil {
+		return "", err
+	}
+	if exp.Template == "" {
+		return filepath.Join(folder, exportPath(name, exp)), nil
+	}
+
+	var meta photoMeta
+	if templateNeedsMeta(exp.Template) {
+		meta, err = loadPhotoMeta(ctx, path)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	base := filepath.Base(name)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	res, err := expandTemplate(exp.Template, base, seq, meta, exp)
+	if err != nil {
+		return "", err
+	}
+
+	// keep subfolders of the original name
+	return filepath.Join(folder, filepath.Dir(name), res) + exportExt(exp), nil
+}
+
+func expandTemplate(tmpl, name string, seq int, meta photoMeta, exp exportSettings) (string, error) {
+	var buf strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			buf.WriteString(tmpl)
+			break
+		}
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			return "", errors.New("unterminated token in filename template")
+		}
+		buf.WriteString(tmpl[:i])
+		tok, arg, _ := strings.Cut(tmpl[i+1:i+j], ":")
+		tmpl = tmpl[i+j+1:]
+
+		var val string
+		switch tok {
+		case "name":
+			val = name
+		case "date", "time":
+			if meta.Date.IsZero() {
+				val = "unknown"
+				break
+			}
+			if arg == "" {
+				if tok == "date" {
+					arg = "2006-01-02"
+				} else {
+					arg = "150405"
+				}
+			}
+			// layouts may create subfolders
+			var parts []string
+			for _, part := range strings.Split(arg, "/") {
+				parts = append(parts, sanitizeName(meta.Date.Format(part)))
+			}
+			buf.WriteString(strings.Join(parts, "/"))
+			continue
+		case "make":
+			val = meta.Make
+		case "camera":
+			val = meta.Model
+		case "lens":
+			val = meta.Lens
+		case "iso":
+			val = meta.ISO
+		case "seq":
+			width := 4
+			if arg != "" {
+				n, err := strconv.Atoi(arg)
+				if err != nil || n < 1 || n > 10 {
+					return "", fmt.Errorf("invalid sequence width in filename template: %q", arg)
+				}
+				width = n
+			}
+			val = fmt.Sprintf("%0*d", width, seq)
+		case "snapshot":
+			val = exp.Snapshot
+		case "text":
+			val = exp.Text
+		default:
+			return "", fmt.Errorf("unknown token in filename template: %q", tok)
+		}
+		buf.WriteString(sanitizeName(val))
+	}
+
+	res, err := relPath(buf.String())
+	if err != nil {
+		return "", err
+	}
+	if res == "" {
+		return "", errors.New("invalid filename template: empty name")
+	}
+	return res, nil
+}
+
+// relPath cleans a slash separated relative path,
+// which must not escape its base folder.
+func relPath(path string) (string, error) {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", errors.New("invalid path: parent folders are not allowed")
+		}
+		parts = append(parts, sanitizeName(part))
+	}
+	return filepath.Join(parts...), nil
+}
+
+// sanitizeName replaces characters that are invalid in file names.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+type exportNamePreview struct {
+	Photo     string `json:"photo"`
+	Export    string `json:"export,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // not expanded, see previewExportNames
+}
+
+// The number of export names previewed from photo metadata.
+var exportNamesPreviewed = 3
+
+// previewExportNames returns the names photos would be exported as.
+// Templates that read the photo's metadata are slow to expand,
+// so only the first few of those are; the rest are truncated,
+// and listed by photo only.
+func previewExportNames(ctx context.Context, photos []batchPhoto, recipes []exportSettings) []exportNamePreview {
+	var res []exportNamePreview
+	var meta int
+	for _, photo := range photos {
+		for _, exp := range recipes {
+			prev := exportNamePreview{Photo: filepath.ToSlash(photo.Name)}
+			if templateNeedsMeta(exp.Template) {
+				if meta >= exportNamesPreviewed {
+					prev.Truncated = true
+					res = append(res, prev)
+					continue
+				}
+				meta++
+			}
+			if name, err := exportName(ctx, photo.Path, photo.Name, photo.Seq, exp); err != nil {
+				prev.Error = err.Error()
+			} else {
+				prev.Export = filepath.ToSlash(name)
+			}
+			res = append(res, prev)
+		}
+	}
+	return res
+}
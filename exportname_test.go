@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_expandTemplate(t *testing.T) {
+	meta := photoMeta{
+		Date:  time.Date(2023, 4, 5, 13, 14, 15, 0, time.UTC),
+		Make:  "FUJIFILM",
+		Model: "X-T3",
+		Lens:  "XF35mmF1.4 R",
+		ISO:   "800",
+	}
+	exp := exportSettings{Snapshot: "Warm", Text: "a/b"}
+
+	tests := []struct {
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{"{name}", "DSC_0001", false},
+		{"{date}_{time}", "2023-04-05_131415", false},
+		{"{date:2006/01-02}/{name}", "2023/04-05/DSC_0001", false},
+		{"{camera} {lens} ISO{iso}", "X-T3 XF35mmF1.4 R ISO800", false},
+		{"{make}-{seq}", "FUJIFILM-0012", false},
+		{"{seq:2}", "12", false},
+		{"{snapshot}/{text}", "Warm/a_b", false},
+		{"../{name}", "", true},
+		{"{bogus}", "", true},
+		{"{name", "", true},
+		{"{seq:x}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			got, err := expandTemplate(tt.tmpl, "DSC_0001", 12, meta, exp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("expandTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_previewExportNames(t *testing.T) {
+	var photos []batchPhoto
+	for i, name := range []string{"a.dng", "b.dng", "c.dng", "d.dng"} {
+		photos = append(photos, batchPhoto{Path: name, Name: name, Seq: i + 1})
+	}
+
+	// names that don't need metadata are all expanded
+	recipes := []exportSettings{{Template: "{seq}"}, {Template: "{bogus}"}}
+	got := previewExportNames(context.Background(), photos, recipes)
+	if len(got) != len(photos)*len(recipes) {
+		t.Fatalf("previewExportNames() = %d names, want %d", len(got), len(photos)*len(recipes))
+	}
+	for i, prev := range got {
+		switch {
+		case prev.Truncated:
+			t.Errorf("previewExportNames()[%d] = %+v, want it expanded", i, prev)
+		case i%2 == 0:
+			if prev.Export == "" {
+				t.Errorf("previewExportNames()[%d] = %+v, want a name", i, prev)
+			}
+		default:
+			if prev.Error == "" {
+				t.Errorf("previewExportNames()[%d] = %+v, want an error", i, prev)
+			}
+		}
+	}
+
+	// names that need metadata are truncated past the limit
+	defer func(n int) { exportNamesPreviewed = n }(exportNamesPreviewed)
+	exportNamesPreviewed = 0
+	recipes = []exportSettings{{Template: "{name}"}, {Template: "{date}-{seq}"}}
+	got = previewExportNames(context.Background(), photos, recipes)
+	for i, prev := range got {
+		if meta := i%2 == 1; prev.Truncated != meta || (prev.Export == "") != meta {
+			t.Errorf("previewExportNames()[%d] = %+v", i, prev)
+		}
+	}
+}
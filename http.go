@@ -28,6 +28,9 @@ func setupHTTP() *http.Server {
 	mux.Handle("/photo/", http.StripPrefix("/photo", httpHandler(photoHandler)))
 	mux.Handle("/batch/", http.StripPrefix("/batch", httpHandler(batchHandler)))
 	mux.Handle("/thumb/", http.StripPrefix("/thumb", httpHandler(thumbHandler)))
+	mux.Handle("/watermark/", http.StripPrefix("/watermark", httpHandler(watermarkHandler)))
+	mux.Handle("/preset/", http.StripPrefix("/preset", httpHandler(presetHandler)))
+	mux.Handle("/job/", http.StripPrefix("/job", httpHandler(jobHandler)))
 	mux.Handle("/dialog", httpHandler(dialogHandler))
 	mux.Handle("/upload", httpHandler(uploadHandler))
 	mux.Handle("/serverBatch/", httpHandler(serverBatchHandler))
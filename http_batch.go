@@ -1,26 +1,27 @@
 package main
 
 import (
+	"archive/zip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/schema"
+	"github.com/ncruces/rethinkraw/internal/util"
 	"github.com/ncruces/rethinkraw/pkg/osutil"
 	"github.com/ncruces/zenity"
 )
 
-type multiStatus struct {
-	Code  int    `json:"code"`
-	Text  string `json:"text"`
-	Body  any    `json:"response,omitempty"`
-	Done  int    `json:"done,omitempty"`
-	Total int    `json:"total,omitempty"`
-}
-
 func batchHandler(w http.ResponseWriter, r *http.Request) httpResult {
 	if err := r.ParseForm(); err != nil {
 		return httpResult{Status: http.StatusBadRequest, Error: err}
@@ -34,14 +35,44 @@ func batchHandler(w http.ResponseWriter, r *http.Request) httpResult {
 		}
 		return httpResult{Status: http.StatusGone}
 	}
-	photos, err := findPhotos(batch)
+	paths, opts := splitBatch(batch)
+	changed := false
+	for _, key := range batchOptionKeys {
+		if values, ok := r.Form[key]; ok {
+			changed = true
+			opts.Del(key)
+			for _, value := range values {
+				if value := strings.TrimSpace(value); value != "" {
+					opts.Add(key, value)
+				}
+			}
+		}
+	}
+	if changed {
+		if _, err := parseBatchWalk(opts); err != nil {
+			return httpResult{Status: http.StatusBadRequest, Error: err}
+		}
+		if expr := opts.Get("filter"); expr != "" {
+			if _, err := parseBatchFilter(expr); err != nil {
+				return httpResult{Status: http.StatusBadRequest, Error: err}
+			}
+		}
+		return httpResult{Location: "/batch/" + toBatchPath(joinBatch(paths, opts)...)}
+	}
+
+	photos, err := findPhotos(r.Context(), batch)
 	if err != nil {
 		return httpResult{Error: err}
 	}
 
 	_, save := r.Form["save"]
+	_, match := r.Form["match"]
 	_, export := r.Form["export"]
 	_, settings := r.Form["settings"]
+	_, filenames := r.Form["filenames"]
+	_, description := r.Form["description"]
+	_, sheet := r.Form["sheet"]
+	_, zipped := r.Form["zip"]
 
 	switch {
 	case save:
@@ -53,30 +84,57 @@ func batchHandler(w http.ResponseWriter, r *http.Request) httpResult {
 		}
 		xmp.Orientation = 0
 
-		results := batchProcess(r.Context(), photos, func(ctx context.Context, photo batchPhoto) error {
-			xmp := xmp
-			xmp.Filename = filepath.Base(photo.Path)
-			return saveEdit(ctx, photo.Path, xmp)
-		})
+		return sendJob(w, r, photos, jobParams{Kind: "save", XMP: xmp})
 
-		w.Header().Set("Content-Type", "application/x-ndjson")
-		w.WriteHeader(http.StatusMultiStatus)
-		batchResultWriter(w, results, len(photos))
-		return httpResult{}
+	case match:
+		var ref struct{ Reference string }
+		dec := schema.NewDecoder()
+		dec.IgnoreUnknownKeys(true)
+		if err := dec.Decode(&ref, r.Form); err != nil {
+			return httpResult{Error: err}
+		}
+		if ref.Reference == "" {
+			return httpResult{Status: http.StatusBadRequest, Message: "missing reference photo"}
+		}
+
+		refpath := fromURLPath(ref.Reference, prefix)
+		xmp, err := loadEdit(r.Context(), refpath)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		target, err := loadLuminance(r.Context(), refpath, xmp)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+
+		return sendJob(w, r, photos, jobParams{Kind: "match", Target: target, RefPath: refpath})
 
 	case export:
 		var xmp xmpSettings
-		var exp exportSettings
 		dec := schema.NewDecoder()
 		dec.IgnoreUnknownKeys(true)
 		if err := dec.Decode(&xmp, r.Form); err != nil {
 			return httpResult{Error: err}
 		}
-		if err := dec.Decode(&exp, r.Form); err != nil {
+		recipes, err := decodeExportRecipes(r.Form)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		run, err := decodeExportRun(r.Form)
+		if err != nil {
 			return httpResult{Error: err}
 		}
 		xmp.Orientation = 0
 
+		// remote clients can't access the server's filesystem
+		if zipped || !isLocalhost(r) {
+			if run.DryRun {
+				return httpResult{Status: http.StatusBadRequest, Message: "dry run requires exporting to a folder"}
+			}
+			exportZIP(w, r, batchTitle(batch)+".zip", photos, xmp, recipes)
+			return httpResult{}
+		}
+
 		var exppath string
 		if len(photos) > 0 {
 			exppath = filepath.Dir(photos[0].Path)
@@ -91,24 +149,72 @@ func batchHandler(w http.ResponseWriter, r *http.Request) httpResult {
 			}
 		}
 
-		results := batchProcess(r.Context(), photos, func(ctx context.Context, photo batchPhoto) error {
-			err := batchProcessPhoto(ctx, photo, exppath, xmp, exp)
-			if err == nil && exp.Both {
-				err = batchProcessPhoto(ctx, photo, exppath, xmp, exportSettings{})
-			}
-			return err
-		})
+		return sendJob(w, r, photos, jobParams{Kind: "export", XMP: xmp, Dir: exppath, Recipes: recipes, exportRun: run})
+
+	case description:
+		if r := sendAllowed(w, r, "POST"); r.Done() {
+			return r
+		}
+		desc, fields := parseDescription(r.Form)
+		if len(fields) == 0 {
+			return httpResult{Status: http.StatusBadRequest, Message: "no description fields"}
+		}
+
+		return sendJob(w, r, photos, jobParams{Kind: "description", Desc: desc, Fields: fields})
 
-		w.Header().Set("Content-Type", "application/x-ndjson")
-		w.WriteHeader(http.StatusMultiStatus)
-		batchResultWriter(w, results, len(photos))
+	case sheet:
+		var settings sheetSettings
+		dec := schema.NewDecoder()
+		dec.IgnoreUnknownKeys(true)
+		if err := dec.Decode(&settings, r.Form); err != nil {
+			return httpResult{Error: err}
+		}
+		if len(photos) == 0 {
+			return httpResult{Status: http.StatusNoContent}
+		}
+
+		sheetPhotos, err := loadSheetPhotos(r.Context(), photos)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+
+		title := batchTitle(batch)
+
+		var out []byte
+		var name, ctype string
+		if settings.Format == "jpeg" {
+			out, err = contactSheetJPEG(title, sheetPhotos, settings)
+			name, ctype = title+".jpg", "image/jpeg"
+		} else {
+			out, err = contactSheetPDF(title, sheetPhotos, settings)
+			name, ctype = title+".pdf", "application/pdf"
+		}
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+util.PercentEncode(name))
+		w.Write(out)
+		return httpResult{}
+
+	case filenames:
+		recipes, err := decodeExportRecipes(r.Form)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(previewExportNames(r.Context(), photos, recipes)); err != nil {
+			return httpResult{Error: err}
+		}
 		return httpResult{}
 
 	case settings:
 		if len(photos) == 0 {
 			return httpResult{Status: http.StatusNoContent}
 		}
-		if xmp, err := loadEdit(photos[0].Path); err != nil {
+		if xmp, err := loadEdit(r.Context(), photos[0].Path); err != nil {
 			return httpResult{Error: err}
 		} else {
 			w.Header().Set("Content-Type", "application/json")
@@ -124,10 +230,16 @@ func batchHandler(w http.ResponseWriter, r *http.Request) httpResult {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 		data := struct {
-			Export bool
-			Photos []struct{ Name, Path string }
+			Filter, Include, Exclude string
+			Depth, Symlinks, Sort    string
+			Photos                   []struct{ Name, Path string }
 		}{
-			isLocalhost(r), nil,
+			Filter:   opts.Get("filter"),
+			Include:  strings.Join(opts["include"], ";"),
+			Exclude:  strings.Join(opts["exclude"], ";"),
+			Depth:    opts.Get("depth"),
+			Symlinks: opts.Get("symlinks"),
+			Sort:     opts.Get("sort"),
 		}
 
 		for _, photo := range photos {
@@ -141,48 +253,307 @@ func batchHandler(w http.ResponseWriter, r *http.Request) httpResult {
 	}
 }
 
-func batchProcessPhoto(ctx context.Context, photo batchPhoto, exppath string, xmp xmpSettings, exp exportSettings) error {
+// batchOptionKeys are the options a query can change in a batch path.
+var batchOptionKeys = []string{"filter", "include", "exclude", "depth", "symlinks", "sort"}
+
+// batchTitle names a batch after its folder.
+func batchTitle(batch []string) string {
+	if paths, _ := splitBatch(batch); len(paths) == 1 {
+		return filepath.Base(paths[0])
+	}
+	return "Batch"
+}
+
+// exportedPhoto are the files exported from a photo.
+type exportedPhoto struct {
+	Names []string
+	Out   []exportOutput
+}
+
+// exportPhoto exports a photo once for each recipe,
+// returning the relative names of the exported files.
+func exportPhoto(ctx context.Context, photo batchPhoto, xmp xmpSettings, recipes []exportSettings) (*exportedPhoto, error) {
+	names, err := exportNames(ctx, photo, recipes)
+	if err != nil {
+		return nil, err
+	}
+
 	xmp.Filename = filepath.Base(photo.Path)
-	out, err := exportEdit(ctx, photo.Path, xmp, exp)
+	out, err := exportEdits(ctx, photo.Path, xmp, recipes)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &exportedPhoto{names, out}, nil
+}
 
-	exppath = filepath.Join(exppath, exportPath(photo.Name, exp))
-	if err := os.MkdirAll(filepath.Dir(exppath), 0777); err != nil {
-		return err
+// exportNames returns the relative names of the files exported from a photo.
+// Recipes that export to the same file are rejected.
+func exportNames(ctx context.Context, photo batchPhoto, recipes []exportSettings) ([]string, error) {
+	names := make([]string, len(recipes))
+	seen := make(map[string]int, len(recipes))
+	for i, exp := range recipes {
+		name, err := exportName(ctx, photo.Path, photo.Name, photo.Seq, exp)
+		if err != nil {
+			return nil, err
+		}
+		// file systems may be case insensitive
+		key := strings.ToLower(filepath.Clean(name))
+		if j, ok := seen[key]; ok {
+			return nil, fmt.Errorf("export recipes %d and %d both export %s to %q", j+1, i+1, photo.Name, name)
+		}
+		seen[key] = i
+		names[i] = name
 	}
-	f, err := osutil.NewFile(exppath)
+	return names, nil
+}
+
+// exportRun are the options of a batch export run.
+type exportRun struct {
+	Exists string `json:"exists,omitempty"` // uniquify (default), skip or overwrite
+	DryRun bool   `json:"dryRun,omitempty"` // report planned actions, without exporting
+}
+
+// What a batch export does when a file exists.
+const (
+	existsUniquify  = "uniquify"  // rename the new file
+	existsSkip      = "skip"      // skip it, if newer than the photo and its sidecar
+	existsOverwrite = "overwrite" // overwrite it
+)
+
+// Actions a batch export takes for each file.
+const (
+	exportCreate    = "create"
+	exportRename    = "rename"
+	exportSkip      = "skip"
+	exportOverwrite = "overwrite"
+)
+
+func decodeExportRun(form url.Values) (run exportRun, err error) {
+	dec := schema.NewDecoder()
+	dec.IgnoreUnknownKeys(true)
+	if err := dec.Decode(&run, form); err != nil {
+		return run, err
+	}
+	switch run.Exists {
+	case "", existsUniquify, existsSkip, existsOverwrite:
+		return run, nil
+	default:
+		return run, errors.New("invalid exists policy: " + strconv.Quote(run.Exists))
+	}
+}
+
+// exportReport describes a file written, or planned, by a batch export.
+type exportReport struct {
+	File    string `json:"file"`
+	Action  string `json:"action"`
+	Size    int    `json:"size,omitempty"`
+	Quality *int   `json:"quality,omitempty"`
+}
+
+func batchProcessPhoto(ctx context.Context, photo batchPhoto, p *jobParams) ([]exportReport, error) {
+	names, err := exportNames(ctx, photo, p.Recipes)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// plan what to do with each file, and export only those not skipped
+	var recipes []exportSettings
+	var index []int
+	report := make([]exportReport, len(names))
+	for i, name := range names {
+		path := filepath.Join(p.Dir, name)
+		action, err := exportAction(ctx, photo.Path, path, p.Exists)
+		if err != nil {
+			return nil, err
+		}
+		if action == exportRename && p.DryRun {
+			path, err = osutil.NewFilename(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		report[i] = exportReport{File: path, Action: action}
+		if action != exportSkip {
+			recipes = append(recipes, p.Recipes[i])
+			index = append(index, i)
+		}
+	}
+	if p.DryRun || len(recipes) == 0 {
+		return report, nil
+	}
+
+	xmp := p.XMP
+	xmp.Filename = filepath.Base(photo.Path)
+	out, err := exportEdits(ctx, photo.Path, xmp, recipes)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, exp := range out {
+		r := &report[index[k]]
+		r.File, err = writeExport(r.File, exp.Data, r.Action == exportOverwrite)
+		if err != nil {
+			return nil, err
+		}
+		r.Size = len(exp.Data)
+		r.Quality = exp.Quality
+	}
+	return report, nil
+}
+
+// exportAction decides what to do with an exported file, given a policy for existing files.
+func exportAction(ctx context.Context, src, dest, exists string) (string, error) {
+	fi, err := os.Stat(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return exportCreate, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch exists {
+	case existsOverwrite:
+		return exportOverwrite, nil
+	case existsSkip:
+		// skip up to date files, overwrite stale ones
+		newer, err := newerThanPhoto(ctx, src, fi.ModTime())
+		if err != nil {
+			return "", err
+		}
+		if newer {
+			return exportSkip, nil
+		}
+		return exportOverwrite, nil
+	default:
+		return exportRename, nil
+	}
+}
+
+// newerThanPhoto checks if t is after the last modification of a photo and its sidecar.
+func newerThanPhoto(ctx context.Context, path string, t time.Time) (bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if !t.After(fi.ModTime()) {
+		return false, nil
+	}
+
+	sidecar, err := destSidecar(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	fi, err = os.Stat(sidecar)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.After(fi.ModTime()), nil
+}
+
+// writeExport writes an export, creating its folder, and returns the path written.
+// Unless overwriting, it writes a new file, renaming it to avoid conflicts.
+func writeExport(path string, data []byte, overwrite bool) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return "", err
+	}
+	if overwrite {
+		return path, os.WriteFile(path, data, 0666)
+	}
+
+	f, err := osutil.NewFile(path)
+	if err != nil {
+		return "", err
 	}
 	defer f.Close()
 
-	_, err = f.Write(out)
+	_, err = f.Write(data)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return f.Close()
+	return f.Name(), f.Close()
 }
 
-func batchResultWriter(w http.ResponseWriter, results <-chan error, total int) {
-	i := 0
-	enc := json.NewEncoder(w)
+// exportZIP exports photos, and streams them as a ZIP file.
+func exportZIP(w http.ResponseWriter, r *http.Request, name string, photos []batchPhoto, xmp xmpSettings, recipes []exportSettings) {
+	results := batchProcess(r.Context(), photos, func(ctx context.Context, photo batchPhoto) (any, error) {
+		exported, _, err := retry(ctx, photo.Name, func() (any, error) {
+			return exportPhoto(ctx, photo, xmp, recipes)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", photo.Name, err)
+		}
+		return exported, nil
+	})
+	zipResultWriter(w, results, name)
+}
+
+// zipResultWriter streams exported photos as a ZIP file, as they're exported.
+// Since the response has started, failures are listed in an errors.txt file.
+func zipResultWriter(w http.ResponseWriter, results <-chan batchResult, name string) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+util.PercentEncode(name))
+
+	zw := zip.NewWriter(w)
 	flush, _ := w.(http.Flusher)
-	for err := range results {
-		i += 1
-		var status multiStatus
+	names := map[string]struct{}{}
+	var failed []string
+	var err error
+
+	create := func(name string, data []byte) error {
+		name = uniqueName(names, name)
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Store, // already compressed
+			Modified: time.Now(),
+		})
 		if err != nil {
-			status.Code, status.Body = errorStatus(err)
-		} else {
-			status.Code = http.StatusOK
+			return err
 		}
-		status.Done, status.Total = i, total
-		status.Text = http.StatusText(status.Code)
-		enc.Encode(status)
+		_, err = f.Write(data)
+		return err
+	}
 
-		if flush != nil {
+	// keep draining results, even after a write fails
+	for res := range results {
+		if res.Err != nil {
+			failed = append(failed, res.Err.Error())
+			continue
+		}
+		exported := res.Response.(*exportedPhoto)
+		for i, out := range exported.Out {
+			if err == nil {
+				err = create(filepath.ToSlash(exported.Names[i]), out.Data)
+			}
+		}
+		if err == nil {
+			err = zw.Flush()
+		}
+		if flush != nil && err == nil {
 			flush.Flush()
 		}
 	}
+
+	if len(failed) > 0 && err == nil {
+		err = create("errors.txt", []byte(strings.Join(failed, "\n")+"\n"))
+	}
+	if err == nil {
+		zw.Close()
+	}
+}
+
+// uniqueName renames a file, to avoid conflicts with names already used,
+// and adds it to used.
+func uniqueName(used map[string]struct{}, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		if _, ok := used[name]; !ok {
+			used[name] = struct{}{}
+			return name
+		}
+		name = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
 }
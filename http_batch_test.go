@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_zipResultWriter(t *testing.T) {
+	results := make(chan batchResult, 3)
+	results <- batchResult{Response: &exportedPhoto{
+		Names: []string{"a.jpg", "sub/b.jpg"},
+		Out:   []exportOutput{{Data: []byte("a")}, {Data: []byte("b")}},
+	}}
+	results <- batchResult{Response: &exportedPhoto{
+		Names: []string{"a.jpg"},
+		Out:   []exportOutput{{Data: []byte("c")}},
+	}}
+	results <- batchResult{Err: errors.New("c.cr2: failed")}
+	close(results)
+
+	w := httptest.NewRecorder()
+	zipResultWriter(w, results, "batch.zip")
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"a.jpg":      "a",
+		"sub/b.jpg":  "b",
+		"a (1).jpg":  "c",
+		"errors.txt": "c.cr2: failed\n",
+	}
+	if len(zr.File) != len(want) {
+		t.Errorf("got %d files, want %d", len(zr.File), len(want))
+	}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(data); got != want[f.Name] {
+			t.Errorf("%s = %q, want %q", f.Name, got, want[f.Name])
+		}
+	}
+}
+
+func Test_decodeExportRun(t *testing.T) {
+	run, err := decodeExportRun(url.Values{"exists": {"skip"}, "dryrun": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run != (exportRun{Exists: existsSkip, DryRun: true}) {
+		t.Errorf("decodeExportRun() = %+v", run)
+	}
+	if _, err := decodeExportRun(url.Values{"exists": {"ask"}}); err == nil {
+		t.Error("decodeExportRun(ask) should fail")
+	}
+}
+
+func Test_exportAction(t *testing.T) {
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.cr2")
+	dest := filepath.Join(dir, "photo.jpg")
+	for _, name := range []string{photo, dest} {
+		if err := os.WriteFile(name, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	check := func(exists, want string) {
+		t.Helper()
+		got, err := exportAction(ctx, photo, dest, exists)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("exportAction(%q) = %q, want %q", exists, got, want)
+		}
+	}
+
+	check("", exportRename)
+	check(existsOverwrite, exportOverwrite)
+
+	// the export is newer than the photo, but not its sidecar
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(photo, old, old)
+	check(existsSkip, exportSkip)
+	if err := os.WriteFile(filepath.Join(dir, "photo.xmp"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(dest, old, old.Add(time.Minute))
+	check(existsSkip, exportOverwrite)
+
+	if err := os.Remove(dest); err != nil {
+		t.Fatal(err)
+	}
+	check(existsSkip, exportCreate)
+}
+
+func Test_exportNames(t *testing.T) {
+	ctx := context.Background()
+	photo := batchPhoto{Path: "/photos/sub/IMG_1.CR2", Name: "sub/IMG_1.CR2", Seq: 1}
+
+	names, err := exportNames(ctx, photo, []exportSettings{
+		{DNG: true}, {}, {Folder: "web"}, {Template: "{name}-{seq}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"sub/IMG_1.dng", "sub/IMG_1.jpg", "web/sub/IMG_1.jpg", "sub/IMG_1-0001.jpg"}
+	for i := range want {
+		if names[i] != filepath.FromSlash(want[i]) {
+			t.Errorf("exportNames() = %q, want %q", names, want)
+			break
+		}
+	}
+
+	for _, recipes := range [][]exportSettings{
+		{{}, {}},
+		{{Folder: "web"}, {}, {Folder: "WEB"}},
+		{{}, {Template: "{name}"}},
+	} {
+		if _, err := exportNames(ctx, photo, recipes); err == nil {
+			t.Errorf("exportNames(%+v) accepted duplicate names", recipes)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func jobHandler(w http.ResponseWriter, r *http.Request) httpResult {
+	if r := sendAllowed(w, r, "GET", "HEAD", "POST", "DELETE"); r.Done() {
+		return r
+	}
+	if err := r.ParseForm(); err != nil {
+		return httpResult{Status: http.StatusBadRequest, Error: err}
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/")
+
+	_, pause := r.Form["pause"]
+	_, resume := r.Form["resume"]
+	_, cancel := r.Form["cancel"]
+	_, report := r.Form["report"]
+
+	var res any
+	switch {
+	case r.Method == "POST":
+		var state string
+		switch {
+		case pause:
+			state = jobPaused
+		case resume:
+			state = jobRunning
+		case cancel:
+			state = jobCanceled
+		default:
+			return httpResult{Status: http.StatusBadRequest, Message: "missing job operation"}
+		}
+		status, err := setJobState(id, state)
+		if errors.Is(err, os.ErrNotExist) {
+			return httpResult{Error: err}
+		}
+		if err != nil {
+			return httpResult{Status: http.StatusConflict, Error: err}
+		}
+		res = status
+
+	case r.Method == "DELETE":
+		err := deleteJob(id)
+		if errors.Is(err, os.ErrNotExist) {
+			return httpResult{Error: err}
+		}
+		if err != nil {
+			return httpResult{Status: http.StatusConflict, Error: err}
+		}
+		return httpResult{Status: http.StatusNoContent}
+
+	case id == "":
+		res = listJobs()
+
+	case report:
+		j, err := getJob(id)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		if r.Form.Get("report") == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			err = writeReportCSV(w, j.report())
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			err = writeReportJSON(w, j.report())
+		}
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		return httpResult{}
+
+	default:
+		j, err := getJob(id)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		res = struct {
+			jobStatus
+			Items []jobItem `json:"items"`
+		}{j.status(), j.Items}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return httpResult{Error: err}
+	}
+	return httpResult{}
+}
+
+// sendJob starts a job, and responds with its status.
+//
+// Synchronous requests (with a sync parameter) are processed while the client waits,
+// instead: the result of each photo is streamed as newline-delimited JSON.
+func sendJob(w http.ResponseWriter, r *http.Request, photos []batchPhoto, params jobParams) httpResult {
+	if _, sync := r.Form["sync"]; sync {
+		results := batchProcess(r.Context(), photos, func(ctx context.Context, photo batchPhoto) (any, error) {
+			res, _, err := retry(ctx, photo.Name, func() (any, error) {
+				return params.process(ctx, photo)
+			})
+			return res, err
+		})
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusMultiStatus)
+		batchResultWriter(w, results, len(photos))
+		return httpResult{}
+	}
+
+	status, err := newJob(photos, params)
+	if err != nil {
+		return httpResult{Error: err}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/job/"+status.ID)
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		return httpResult{Error: err}
+	}
+	return httpResult{}
+}
+
+// multiStatus is the result of a photo in a synchronous request.
+type multiStatus struct {
+	Code  int    `json:"code"`
+	Text  string `json:"text"`
+	Body  any    `json:"response,omitempty"`
+	Done  int    `json:"done,omitempty"`
+	Total int    `json:"total,omitempty"`
+}
+
+func batchResultWriter(w http.ResponseWriter, results <-chan batchResult, total int) {
+	i := 0
+	enc := json.NewEncoder(w)
+	flush, _ := w.(http.Flusher)
+	for res := range results {
+		i += 1
+		var status multiStatus
+		if res.Err != nil {
+			status.Code, status.Body = errorStatus(res.Err)
+		} else {
+			status.Code = http.StatusOK
+			status.Body = res.Response
+		}
+		status.Done, status.Total = i, total
+		status.Text = http.StatusText(status.Code)
+		enc.Encode(status)
+
+		if flush != nil {
+			flush.Flush()
+		}
+	}
+}
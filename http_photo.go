@@ -26,6 +26,8 @@ func photoHandler(w http.ResponseWriter, r *http.Request) httpResult {
 	_, preview := r.Form["preview"]
 	_, settings := r.Form["settings"]
 	_, whiteBalance := r.Form["wb"]
+	_, filenames := r.Form["filenames"]
+	_, description := r.Form["description"]
 
 	switch {
 	case meta:
@@ -34,7 +36,7 @@ func photoHandler(w http.ResponseWriter, r *http.Request) httpResult {
 			return r
 		}
 
-		if out, err := getMetaHTML(path); err != nil {
+		if out, err := getMetaHTML(r.Context(), path); err != nil {
 			return httpResult{Error: err}
 		} else {
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -59,18 +61,22 @@ func photoHandler(w http.ResponseWriter, r *http.Request) httpResult {
 
 	case export:
 		var xmp xmpSettings
-		var exp exportSettings
 		dec := schema.NewDecoder()
 		dec.IgnoreUnknownKeys(true)
 		if err := dec.Decode(&xmp, r.Form); err != nil {
 			return httpResult{Error: err}
 		}
-		if err := dec.Decode(&exp, r.Form); err != nil {
+		exp, err := decodeExportSettings(r.Form)
+		if err != nil {
 			return httpResult{Error: err}
 		}
 		xmp.Filename = filepath.Base(path)
 
-		exppath := exportPath(path, exp)
+		name, err := exportName(r.Context(), path, filepath.Base(path), 1, exp)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		exppath := filepath.Join(filepath.Dir(path), name)
 		if isLocalhost(r) {
 			if res, err := zenity.SelectFileSave(zenity.Context(r.Context()), zenity.Filename(exppath), zenity.ConfirmOverwrite()); res != "" {
 				exppath = res
@@ -96,6 +102,10 @@ func photoHandler(w http.ResponseWriter, r *http.Request) httpResult {
 			w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+util.PercentEncode(name))
 			if exp.DNG {
 				w.Header().Set("Content-Type", "image/x-adobe-dng")
+			} else if exp.TIFF {
+				w.Header().Set("Content-Type", "image/tiff")
+			} else if exp.PNG {
+				w.Header().Set("Content-Type", "image/png")
 			} else {
 				w.Header().Set("Content-Type", "image/jpeg")
 			}
@@ -105,16 +115,24 @@ func photoHandler(w http.ResponseWriter, r *http.Request) httpResult {
 
 	case preview:
 		var xmp xmpSettings
-		var size struct{ Preview int }
+		var opts struct {
+			Preview  int
+			Clipping bool
+		}
 		dec := schema.NewDecoder()
 		dec.IgnoreUnknownKeys(true)
 		if err := dec.Decode(&xmp, r.Form); err != nil {
 			return httpResult{Error: err}
 		}
-		if err := dec.Decode(&size, r.Form); err != nil {
+		if err := dec.Decode(&opts, r.Form); err != nil {
 			return httpResult{Error: err}
 		}
-		if out, err := previewEdit(r.Context(), path, size.Preview, xmp); err != nil {
+		if err := workers.acquire(r.Context()); err != nil {
+			return httpResult{Error: err}
+		}
+		defer workers.release(r.Context())
+
+		if out, err := previewEdit(r.Context(), path, opts.Preview, opts.Clipping, xmp); err != nil {
 			return httpResult{Error: err}
 		} else {
 			w.Header().Set("Content-Type", "image/jpeg")
@@ -123,7 +141,7 @@ func photoHandler(w http.ResponseWriter, r *http.Request) httpResult {
 		}
 
 	case settings:
-		if xmp, err := loadEdit(path); err != nil {
+		if xmp, err := loadEdit(r.Context(), path); err != nil {
 			return httpResult{Error: err}
 		} else {
 			w.Header().Set("Content-Type", "application/json")
@@ -134,6 +152,40 @@ func photoHandler(w http.ResponseWriter, r *http.Request) httpResult {
 		}
 		return httpResult{}
 
+	case description && r.Method == "POST":
+		desc, fields := parseDescription(r.Form)
+		if err := saveDescription(r.Context(), path, desc, fields); err != nil {
+			return httpResult{Error: err}
+		} else {
+			return httpResult{Status: http.StatusNoContent}
+		}
+
+	case description:
+		if desc, err := loadDescription(r.Context(), path); err != nil {
+			return httpResult{Error: err}
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			if err := enc.Encode(desc); err != nil {
+				return httpResult{Error: err}
+			}
+		}
+		return httpResult{}
+
+	case filenames:
+		exp, err := decodeExportSettings(r.Form)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+
+		photos := []batchPhoto{{path, filepath.Base(path), 1}}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(previewExportNames(r.Context(), photos, []exportSettings{exp})); err != nil {
+			return httpResult{Error: err}
+		}
+		return httpResult{}
+
 	case whiteBalance:
 		var coords struct{ WB []float64 }
 		dec := schema.NewDecoder()
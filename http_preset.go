@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/schema"
+)
+
+func presetHandler(w http.ResponseWriter, r *http.Request) httpResult {
+	if r := sendAllowed(w, r, "GET", "HEAD", "PUT", "POST", "DELETE"); r.Done() {
+		return r
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+
+	var res any
+	switch {
+	case r.Method == "PUT":
+		var exp exportSettings
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&exp); err != nil {
+			return httpResult{Status: http.StatusBadRequest, Error: err}
+		}
+		if err := savePreset(name, exp); err != nil {
+			return httpResult{Status: http.StatusBadRequest, Error: err}
+		}
+		return httpResult{Status: http.StatusNoContent}
+
+	case r.Method == "POST":
+		// save the export settings in the form
+		if err := r.ParseForm(); err != nil {
+			return httpResult{Status: http.StatusBadRequest, Error: err}
+		}
+		var exp exportSettings
+		dec := schema.NewDecoder()
+		dec.IgnoreUnknownKeys(true)
+		if err := dec.Decode(&exp, r.Form); err != nil {
+			return httpResult{Status: http.StatusBadRequest, Error: err}
+		}
+		if err := savePreset(name, exp); err != nil {
+			return httpResult{Status: http.StatusBadRequest, Error: err}
+		}
+		return httpResult{Status: http.StatusNoContent}
+
+	case r.Method == "DELETE":
+		if err := deletePreset(name); err != nil {
+			return httpResult{Error: err}
+		}
+		return httpResult{Status: http.StatusNoContent}
+
+	case name == "":
+		names, err := listPresets()
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		res = names
+
+	default:
+		exp, err := loadPreset(name)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		res = exp
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return httpResult{Error: err}
+	}
+	return httpResult{}
+}
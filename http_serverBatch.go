@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"io"
 	"net/http"
 	"os"
@@ -31,40 +30,41 @@ func serverBatchHandler(w http.ResponseWriter, r *http.Request) httpResult {
 		batchPath := strings.Replace(currentPath, "/serverBatch/", "", -1)
 		batch := fromBatchPath(batchPath)
 
-		photos, err := findPhotos(batch)
+		photos, err := findPhotos(r.Context(), batch)
 		if err != nil {
 			return httpResult{Error: err}
 		}
 
 		var xmp xmpSettings
-		var exp exportSettings
 		dec := schema.NewDecoder()
 		dec.IgnoreUnknownKeys(true)
 		if err := dec.Decode(&xmp, r.Form); err != nil {
 			return httpResult{Error: err}
 		}
-		if err := dec.Decode(&exp, r.Form); err != nil {
+		recipes, err := decodeExportRecipes(r.Form)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		run, err := decodeExportRun(r.Form)
+		if err != nil {
 			return httpResult{Error: err}
 		}
 		xmp.Orientation = 0
 
-		exppath := r.Form["output"][0]
+		// without an output folder, stream a ZIP file
+		exppath := r.Form.Get("output")
+		if exppath == "" {
+			if run.DryRun {
+				return httpResult{Status: http.StatusBadRequest, Message: "dry run requires an output folder"}
+			}
+			exportZIP(w, r, batchTitle(batch)+".zip", photos, xmp, recipes)
+			return httpResult{}
+		}
 		if _, err := os.Stat(exppath); err != nil {
 			return httpResult{Error: err}
 		}
 
-		results := batchProcess(r.Context(), photos, func(ctx context.Context, photo batchPhoto) error {
-			err := batchProcessPhoto(ctx, photo, exppath, xmp, exp)
-			if err == nil && exp.Both {
-				err = batchProcessPhoto(ctx, photo, exppath, xmp, exportSettings{})
-			}
-			return err
-		})
-
-		w.Header().Set("Content-Type", "application/x-ndjson")
-		w.WriteHeader(http.StatusMultiStatus)
-		batchResultWriter(w, results, len(photos))
-		return httpResult{}
+		return sendJob(w, r, photos, jobParams{Kind: "export", XMP: xmp, Dir: exppath, Recipes: recipes, exportRun: run})
 	}
 	return httpResult{}
 }
@@ -19,6 +19,11 @@ func thumbHandler(w http.ResponseWriter, r *http.Request) httpResult {
 		return httpResult{}
 	}
 
+	if err := workers.acquire(r.Context()); err != nil {
+		return httpResult{Error: err}
+	}
+	defer workers.release(r.Context())
+
 	if out, err := previewJPEG(r.Context(), path); err != nil {
 		return httpResult{Error: err}
 	} else {
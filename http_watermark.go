@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+func watermarkHandler(w http.ResponseWriter, r *http.Request) httpResult {
+	if r := sendAllowed(w, r, "GET", "HEAD", "PUT", "DELETE"); r.Done() {
+		return r
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+
+	var res any
+	switch {
+	case r.Method == "PUT":
+		var wm watermark
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 10<<20)).Decode(&wm); err != nil {
+			return httpResult{Status: http.StatusBadRequest, Error: err}
+		}
+		if err := saveWatermark(name, wm); err != nil {
+			return httpResult{Status: http.StatusBadRequest, Error: err}
+		}
+		return httpResult{Status: http.StatusNoContent}
+
+	case r.Method == "DELETE":
+		if err := deleteWatermark(name); err != nil {
+			return httpResult{Error: err}
+		}
+		return httpResult{Status: http.StatusNoContent}
+
+	case name == "":
+		names, err := listWatermarks()
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		res = names
+
+	default:
+		wm, err := loadWatermark(name)
+		if err != nil {
+			return httpResult{Error: err}
+		}
+		res = wm
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return httpResult{Error: err}
+	}
+	return httpResult{}
+}
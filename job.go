@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ncruces/rethinkraw/internal/util"
+)
+
+// Batch jobs run independently of the requests that start them.
+//
+// Job state is persisted in the data directory, every few seconds while it runs,
+// and whenever it stops, so that running jobs are resumed after a restart.
+// Pausing or canceling a job stops it; items in progress are left pending,
+// so resuming a paused job processes them again.
+// Finished jobs are deleted after a while.
+
+const (
+	jobRunning  = "running"
+	jobPaused   = "paused"
+	jobCanceled = "canceled"
+	jobDone     = "done"
+)
+
+const (
+	jobSaveInterval = 5 * time.Second
+	jobRetention    = 30 * 24 * time.Hour
+)
+
+type job struct {
+	ID      string    `json:"id"`
+	State   string    `json:"state"`
+	Created time.Time `json:"created"`
+	Params  jobParams `json:"params"`
+	Items   []jobItem `json:"items"`
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	saved  time.Time
+}
+
+// jobParams are the parameters of a batch operation.
+type jobParams struct {
+	Kind string `json:"kind"` // save, match, export or description
+
+	XMP       xmpSettings      `json:"xmp"`
+	Target    float64          `json:"target,omitempty"`    // match
+	RefPath   string           `json:"reference,omitempty"` // match
+	Dir       string           `json:"dir,omitempty"`       // export
+	Recipes   []exportSettings `json:"recipes,omitempty"`   // export
+	exportRun                  // export
+
+	Desc   xmpDescription `json:"description"`      // description
+	Fields []string       `json:"fields,omitempty"` // description
+}
+
+// jobItem is the status of a photo in a job.
+// A zero code means the item is pending.
+type jobItem struct {
+	Path     string  `json:"path"`
+	Name     string  `json:"name"`
+	Seq      int     `json:"seq"`
+	Code     int     `json:"code,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	Response any     `json:"response,omitempty"` // see jobParams.process
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Retries  int     `json:"retries,omitempty"`
+}
+
+// jobStatus summarizes a job.
+type jobStatus struct {
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	State   string    `json:"state"`
+	Created time.Time `json:"created"`
+	Done    int       `json:"done"`
+	Failed  int       `json:"failed"`
+	Total   int       `json:"total"`
+}
+
+var jobs = struct {
+	sync.Mutex
+	wg  sync.WaitGroup
+	all map[string]*job
+}{all: map[string]*job{}}
+
+// newJob creates, and starts, a job to process photos.
+func newJob(photos []batchPhoto, params jobParams) (jobStatus, error) {
+	j := &job{
+		ID:      util.RandomID(),
+		State:   jobRunning,
+		Created: time.Now().UTC(),
+		Params:  params,
+		Items:   make([]jobItem, len(photos)),
+	}
+	for i, photo := range photos {
+		j.Items[i] = jobItem{Path: photo.Path, Name: photo.Name, Seq: photo.Seq}
+	}
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	if err := saveData("jobs", j.ID, j); err != nil {
+		return jobStatus{}, err
+	}
+	jobs.all[j.ID] = j
+	j.start()
+	return j.status(), nil
+}
+
+// resumeJobs loads persisted jobs, and resumes those that were running.
+func resumeJobs() error {
+	names, err := listData("jobs")
+	if err != nil {
+		return err
+	}
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	for _, name := range names {
+		j := &job{}
+		if err := loadData("jobs", name, j); err != nil {
+			log.Printf("job %s: %v", name, err)
+			continue
+		}
+		if j.expired() {
+			if err := deleteData("jobs", name); err != nil {
+				log.Printf("job %s: %v", name, err)
+			}
+			continue
+		}
+		jobs.all[j.ID] = j
+		if j.State == jobRunning {
+			j.start()
+		}
+	}
+	return nil
+}
+
+// stopJobs stops all jobs, without changing their state,
+// so that they are resumed after a restart.
+func stopJobs() {
+	jobs.Lock()
+	for _, j := range jobs.all {
+		if j.cancel != nil {
+			j.cancel()
+		}
+	}
+	jobs.Unlock()
+	jobs.wg.Wait()
+}
+
+func listJobs() []jobStatus {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	res := []jobStatus{}
+	for _, j := range jobs.all {
+		res = append(res, j.status())
+	}
+	sort.Slice(res, func(i, k int) bool { return res[i].Created.Before(res[k].Created) })
+	return res
+}
+
+// getJob returns a copy of a job.
+func getJob(id string) (job, error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	if j, ok := jobs.all[id]; ok {
+		res := *j
+		res.Items = append([]jobItem(nil), j.Items...)
+		return res, nil
+	}
+	return job{}, os.ErrNotExist
+}
+
+// setJobState pauses, resumes or cancels a job.
+func setJobState(id, state string) (jobStatus, error) {
+	jobs.Lock()
+	j, ok := jobs.all[id]
+	if !ok {
+		jobs.Unlock()
+		return jobStatus{}, os.ErrNotExist
+	}
+
+	switch {
+	case j.finished():
+		jobs.Unlock()
+		return jobStatus{}, errors.New("job is " + j.State)
+	case state == jobRunning && j.State == jobPaused:
+		if j.done != nil {
+			jobs.Unlock()
+			return jobStatus{}, errors.New("job is stopping")
+		}
+		j.State = state
+		j.start()
+	case state == jobPaused && j.State == jobRunning:
+		j.State = state
+	case state == jobCanceled:
+		j.State = state
+	}
+
+	stop := j.State != jobRunning
+	cancel, done := j.cancel, j.done
+	if stop && cancel != nil {
+		cancel()
+	}
+	jobs.Unlock()
+
+	// wait for items in progress to stop
+	if stop && done != nil {
+		<-done
+	}
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	if state == jobCanceled && done == nil {
+		// nothing was running to write the report
+		j.logReport()
+	}
+	return j.status(), saveData("jobs", j.ID, j)
+}
+
+// deleteJob deletes a job that isn't running.
+func deleteJob(id string) error {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	j, ok := jobs.all[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if j.State == jobRunning {
+		return errors.New("job is running")
+	}
+	delete(jobs.all, id)
+	return deleteData("jobs", id)
+}
+
+func (j *job) status() jobStatus {
+	res := jobStatus{
+		ID:      j.ID,
+		Kind:    j.Params.Kind,
+		State:   j.State,
+		Created: j.Created,
+		Total:   len(j.Items),
+	}
+	for _, item := range j.Items {
+		if item.Code != 0 {
+			res.Done++
+		}
+		if item.Code >= 400 {
+			res.Failed++
+		}
+	}
+	return res
+}
+
+// start processes the pending items of a job; jobs must be locked.
+func (j *job) start() {
+	var pending []batchPhoto
+	index := map[string]int{}
+	for i, item := range j.Items {
+		if item.Code == 0 {
+			pending = append(pending, batchPhoto{item.Path, item.Name, item.Seq})
+			index[item.Path] = i
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	j.cancel, j.done = cancel, done
+	jobs.wg.Add(1)
+
+	go func() {
+		defer jobs.wg.Done()
+		defer close(done)
+		defer cancel()
+
+		results := batchProcess(ctx, pending, func(ctx context.Context, photo batchPhoto) (any, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			start := time.Now()
+			res, retries, err := retry(ctx, photo.Name, func() (any, error) {
+				return j.Params.process(ctx, photo)
+			})
+			if err != nil && ctx.Err() != nil {
+				// stopped: leave it pending
+				return nil, err
+			}
+
+			jobs.Lock()
+			defer jobs.Unlock()
+			item := &j.Items[index[photo.Path]]
+			if err != nil {
+				item.Code, item.Text = errorStatus(err)
+			} else {
+				item.Code, item.Text, item.Response = http.StatusOK, http.StatusText(http.StatusOK), res
+			}
+			item.Duration = time.Since(start).Seconds()
+			item.Retries = retries
+			if time.Since(j.saved) >= jobSaveInterval {
+				j.save()
+			}
+			return res, err
+		})
+		for range results {
+		}
+
+		jobs.Lock()
+		defer jobs.Unlock()
+		if ctx.Err() == nil && j.State == jobRunning {
+			j.State = jobDone
+		}
+		if j.finished() {
+			j.logReport()
+		}
+		j.save()
+		if j.done == done {
+			j.cancel, j.done = nil, nil
+		}
+	}()
+}
+
+// save persists a job; jobs must be locked.
+func (j *job) save() {
+	if err := saveData("jobs", j.ID, j); err != nil {
+		log.Printf("job %s: %v", j.ID, err)
+	} else {
+		j.saved = time.Now()
+	}
+}
+
+// finished reports whether a job is done or canceled, for good.
+func (j *job) finished() bool {
+	return j.State == jobDone || j.State == jobCanceled
+}
+
+// expired reports whether a finished job is past its retention period.
+func (j *job) expired() bool {
+	return j.finished() && time.Since(j.Created) > jobRetention
+}
+
+// Transient failures are retried, with exponential backoff.
+const (
+	jobRetries = 3
+	jobBackoff = 5 * time.Second
+)
+
+// retry processes a photo, retrying transient failures,
+// and returns the number of retries.
+func retry(ctx context.Context, name string, process func() (any, error)) (res any, retries int, err error) {
+	for {
+		res, err = process()
+		if err == nil || retries >= jobRetries || !isTransient(err) {
+			return res, retries, err
+		}
+
+		log.Printf("retrying %s: %v", name, err)
+		select {
+		case <-ctx.Done():
+			return nil, retries, ctx.Err()
+		case <-time.After(jobBackoff << retries):
+			retries++
+		}
+	}
+}
+
+// process processes a photo, and returns its result:
+// an exposureMatch for match jobs, exportReports for export jobs
+// (with the chosen JPEG quality, or the planned actions of dry runs),
+// and nothing for others.
+func (p *jobParams) process(ctx context.Context, photo batchPhoto) (any, error) {
+	switch p.Kind {
+	case "save":
+		xmp := p.XMP
+		xmp.Filename = filepath.Base(photo.Path)
+		return nil, saveEdit(ctx, photo.Path, xmp)
+	case "match":
+		if photo.Path == p.RefPath {
+			return nil, nil
+		}
+		return matchExposure(ctx, photo.Path, p.Target)
+	case "export":
+		return batchProcessPhoto(ctx, photo, p)
+	case "description":
+		return nil, saveDescription(ctx, photo.Path, p.Desc, p.Fields)
+	default:
+		return nil, errors.New("unknown job kind: " + p.Kind)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ncruces/rethinkraw/internal/config"
+)
+
+func Test_jobs(t *testing.T) {
+	dataDir := config.DataDir
+	defer func() { config.DataDir = dataDir }()
+	config.DataDir = t.TempDir()
+
+	wait := func(id string) job {
+		for i := 0; i < 100; i++ {
+			j, err := getJob(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.State != jobRunning {
+				return j
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("job didn't finish")
+		return job{}
+	}
+
+	// unknown kinds fail every item
+	photos := []batchPhoto{{"a.cr2", "a.cr2", 1}, {"b.cr2", "b.cr2", 2}}
+	status, err := newJob(photos, jobParams{Kind: "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := wait(status.ID)
+	if got := (&j).status(); got.State != jobDone || got.Done != 2 || got.Failed != 2 {
+		t.Errorf("job status = %+v", got)
+	}
+	if _, err := setJobState(status.ID, jobRunning); err == nil {
+		t.Error("resuming a done job should fail")
+	}
+
+	// persisted jobs are reloaded, and running jobs are resumed
+	running := job{ID: "running", State: jobRunning, Params: jobParams{Kind: "unknown"},
+		Items: []jobItem{{Path: "c.cr2", Name: "c.cr2", Seq: 1}}}
+	if err := saveData("jobs", running.ID, running); err != nil {
+		t.Fatal(err)
+	}
+	jobs.all = map[string]*job{}
+	if err := resumeJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(listJobs()); got != 2 {
+		t.Errorf("listJobs() = %d jobs, want 2", got)
+	}
+	if got := wait("running"); got.State != jobDone || len(got.Items) != 1 || got.Items[0].Code == 0 {
+		t.Errorf("resumed job = %+v", got)
+	}
+
+	if err := deleteJob(status.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getJob(status.ID); err == nil {
+		t.Error("deleted job still exists")
+	}
+	stopJobs()
+}
+
+func Test_jobReport(t *testing.T) {
+	var loaded any
+	json.Unmarshal([]byte(`[{"file":"/out/b.jpg","action":"skip"}]`), &loaded)
+
+	j := job{Params: jobParams{Kind: "export"}, Items: []jobItem{
+		{Path: "/in/a.cr2", Code: 200, Duration: 1.5, Response: []exportReport{
+			{File: "/out/a.jpg", Action: exportCreate}, {File: "/out/a.dng", Action: exportCreate}}},
+		{Path: "/in/b.cr2", Code: 200, Response: loaded},
+		{Path: "/in/c.cr2", Code: 500, Text: "dng converter: exit status 1", Retries: 3},
+		{Path: "/in/d.cr2"},
+	}}
+
+	var buf strings.Builder
+	if err := writeReportCSV(&buf, j.report()); err != nil {
+		t.Fatal(err)
+	}
+	want := `source,output,action,status,error,duration,retries
+/in/a.cr2,/out/a.jpg,create,200,,1.500,0
+/in/a.cr2,/out/a.dng,create,200,,1.500,0
+/in/b.cr2,/out/b.jpg,skip,200,,0.000,0
+/in/c.cr2,,,500,dng converter: exit status 1,0.000,3
+/in/d.cr2,,,0,,0.000,0
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeReportCSV() = %q, want %q", got, want)
+	}
+}
+
+func Test_setJobState_report(t *testing.T) {
+	dataDir := config.DataDir
+	defer func() { config.DataDir = dataDir }()
+	config.DataDir = t.TempDir()
+
+	// a paused export, canceled, still reports what was done
+	dir := t.TempDir()
+	j := &job{ID: "paused", State: jobPaused, Created: time.Now(),
+		Params: jobParams{Kind: "export", Dir: dir},
+		Items: []jobItem{
+			{Path: "/in/a.cr2", Code: 200, Response: []exportReport{{File: "/out/a.jpg", Action: exportCreate}}},
+			{Path: "/in/b.cr2"},
+		}}
+	jobs.Lock()
+	jobs.all[j.ID] = j
+	jobs.Unlock()
+	defer func() {
+		jobs.Lock()
+		delete(jobs.all, j.ID)
+		jobs.Unlock()
+	}()
+
+	if _, err := setJobState(j.ID, jobCanceled); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []string{".json", ".csv"} {
+		files, _ := filepath.Glob(filepath.Join(dir, "Export report *"+ext))
+		if len(files) != 1 {
+			t.Errorf("canceled job wrote %d %s reports, want 1", len(files), ext)
+		}
+	}
+}
+
+func Test_resumeJobs_expired(t *testing.T) {
+	dataDir := config.DataDir
+	defer func() { config.DataDir = dataDir }()
+	config.DataDir = t.TempDir()
+
+	old := time.Now().Add(-jobRetention - time.Hour)
+	for _, j := range []job{
+		{ID: "done", State: jobDone, Created: old},
+		{ID: "canceled", State: jobCanceled, Created: old},
+		{ID: "paused", State: jobPaused, Created: old},
+		{ID: "recent", State: jobDone, Created: time.Now()},
+	} {
+		if err := saveData("jobs", j.ID, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs.all = map[string]*job{}
+	if err := resumeJobs(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, j := range listJobs() {
+		got = append(got, j.ID)
+	}
+	sort.Strings(got)
+	if want := []string{"paused", "recent"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listJobs() = %q, want %q", got, want)
+	}
+
+	// expired jobs are deleted, and saves leave no temporary files behind
+	names, err := os.ReadDir(filepath.Join(config.DataDir, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Errorf("jobs directory has %d files, want 2", len(names))
+	}
+}
+
+func Test_retry(t *testing.T) {
+	ctx := context.Background()
+
+	// a converter failing on a bad file isn't retried
+	calls := 0
+	_, retries, err := retry(ctx, "bad.cr2", func() (any, error) {
+		calls++
+		return nil, errors.New("dng converter: exit status 1")
+	})
+	if err == nil || calls != 1 || retries != 0 {
+		t.Errorf("retry() = %d calls, %d retries, %v", calls, retries, err)
+	}
+
+	// a transient failure is retried, until the context is canceled
+	ctx, cancel := context.WithCancel(ctx)
+	calls = 0
+	_, retries, err = retry(ctx, "good.cr2", func() (any, error) {
+		calls++
+		cancel()
+		return nil, transientError{errors.New("dng converter: signal: killed")}
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 || retries != 0 {
+		t.Errorf("retry() = %d calls, %d retries, %v", calls, retries, err)
+	}
+}
+
+func Test_sendJob_sync(t *testing.T) {
+	r := httptest.NewRequest("POST", "/batch/x?sync", nil)
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	photos := []batchPhoto{{"a.cr2", "a.cr2", 1}, {"b.cr2", "b.cr2", 2}}
+	sendJob(w, r, photos, jobParams{Kind: "unknown"})
+
+	if w.Code != http.StatusMultiStatus || w.Header().Get("Location") != "" {
+		t.Fatalf("sendJob(sync) = %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != len(photos) {
+		t.Fatalf("sendJob(sync) = %q, want %d lines", lines, len(photos))
+	}
+	for _, line := range lines {
+		var status multiStatus
+		if err := json.Unmarshal([]byte(line), &status); err != nil {
+			t.Fatal(err)
+		}
+		if status.Code != http.StatusInternalServerError || status.Total != len(photos) {
+			t.Errorf("sendJob(sync) = %+v", status)
+		}
+	}
+}
+
+func Test_batchResultWriter(t *testing.T) {
+	quality := 7
+	results := make(chan batchResult, 3)
+	results <- batchResult{Response: exposureMatch{Exposure: 1.5, AutoToneOff: true}}
+	results <- batchResult{Response: []exportReport{{File: "a.jpg", Action: exportCreate, Size: 10, Quality: &quality}}}
+	results <- batchResult{Err: os.ErrNotExist}
+	close(results)
+
+	w := httptest.NewRecorder()
+	batchResultWriter(w, results, 3)
+
+	want := []string{
+		`{"code":200,"text":"OK","response":{"exposure":1.5,"autoToneOff":true},"done":1,"total":3}`,
+		`{"code":200,"text":"OK","response":[{"file":"a.jpg","action":"create","size":10,"quality":7}],"done":2,"total":3}`,
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("batchResultWriter() = %q", lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("batchResultWriter() = %s, want %s", lines[i], want[i])
+		}
+	}
+	if !strings.HasPrefix(lines[2], `{"code":404,`) {
+		t.Errorf("batchResultWriter() = %s, want a 404", lines[2])
+	}
+}
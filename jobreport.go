@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ncruces/rethinkraw/pkg/osutil"
+)
+
+// A job report lists the files written for each photo, or why it failed,
+// how long it took, and how many times it was retried.
+// Reports of export jobs are also written next to the exports, in JSON and CSV,
+// when they are done or canceled.
+
+type jobReportRow struct {
+	Source   string  `json:"source"`
+	Output   string  `json:"output,omitempty"`
+	Action   string  `json:"action,omitempty"`
+	Status   int     `json:"status"` // zero, if pending
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"` // seconds
+	Retries  int     `json:"retries"`
+}
+
+// report returns a row for each file written, or for each photo without files.
+func (j *job) report() []jobReportRow {
+	rows := []jobReportRow{}
+	for _, item := range j.Items {
+		row := jobReportRow{
+			Source:   item.Path,
+			Status:   item.Code,
+			Duration: item.Duration,
+			Retries:  item.Retries,
+		}
+		if item.Code >= 400 {
+			row.Error = item.Text
+		}
+
+		var files []exportReport
+		if j.Params.Kind == "export" && item.Response != nil {
+			// responses loaded from disk are generic JSON values
+			if data, err := json.Marshal(item.Response); err == nil {
+				json.Unmarshal(data, &files)
+			}
+		}
+		if len(files) == 0 {
+			rows = append(rows, row)
+		}
+		for _, f := range files {
+			row.Output, row.Action = f.File, f.Action
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func writeReportJSON(w io.Writer, rows []jobReportRow) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(rows)
+}
+
+func writeReportCSV(w io.Writer, rows []jobReportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"source", "output", "action", "status", "error", "duration", "retries"})
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Source, r.Output, r.Action, strconv.Itoa(r.Status), r.Error,
+			strconv.FormatFloat(r.Duration, 'f', 3, 64), strconv.Itoa(r.Retries),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// logReport writes the report of a finished job, logging failures;
+// jobs must be locked.
+func (j *job) logReport() {
+	if err := j.writeReport(); err != nil {
+		log.Printf("job %s: %v", j.ID, err)
+	}
+}
+
+// writeReport writes the report of an export job next to the exports,
+// whether the job is done, or was canceled; jobs must be locked.
+func (j *job) writeReport() error {
+	if j.Params.Kind != "export" || j.Params.DryRun || j.Params.Dir == "" {
+		return nil
+	}
+
+	rows := j.report()
+	name := filepath.Join(j.Params.Dir, "Export report "+j.Created.Local().Format("2006-01-02 150405"))
+	for _, format := range []struct {
+		ext   string
+		write func(io.Writer, []jobReportRow) error
+	}{
+		{".json", writeReportJSON},
+		{".csv", writeReportCSV},
+	} {
+		f, err := osutil.NewFile(name + format.ext)
+		if err != nil {
+			return err
+		}
+		err = format.write(f, rows)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,8 +5,12 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"image"
+	"image/draw"
 	"image/jpeg"
 	"log"
+	"math"
 	"os"
 
 	"github.com/ncruces/go-image/resize"
@@ -16,6 +20,10 @@ import (
 
 func previewJPEG(ctx context.Context, path string) ([]byte, error) {
 	log.Print("dcraw (get thumb)...")
+	if err := schedDcraw.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer schedDcraw.release(ctx)
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -26,6 +34,10 @@ func previewJPEG(ctx context.Context, path string) ([]byte, error) {
 
 func exportJPEG(ctx context.Context, path string) ([]byte, error) {
 	log.Print("dcraw (get thumb)...")
+	if err := schedDcraw.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer schedDcraw.release(ctx)
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -43,24 +55,187 @@ func exportJPEG(ctx context.Context, path string) ([]byte, error) {
 }
 
 func resampleJPEG(data []byte, settings exportSettings) ([]byte, error) {
+	img, err := exportImage(data, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeJPEG(img, settings.quality(), settings)
+}
+
+// The quality table used for exports.
+// https://fotoforensics.com/tutorial.php?tt=estq
+var jpegQualities = [13]int{30, 34, 47, 62, 69, 76, 79, 82, 86, 90, 93, 97, 99}
+
+func encodeJPEG(img image.Image, quality int, settings exportSettings) ([]byte, error) {
+	if quality < 0 || quality >= len(jpegQualities) {
+		return nil, fmt.Errorf("invalid JPEG quality: %d", quality)
+	}
+
+	buf := bytes.Buffer{}
+	opt := jpeg.Options{Quality: jpegQualities[quality]}
+	if err := jpeg.Encode(&buf, img, &opt); err != nil {
+		return nil, err
+	}
+	return append(jfifHeader(settings), buf.Bytes()[2:]...), nil
+}
+
+// sizedJPEG encodes img at the highest quality (an index into jpegQualities)
+// that fits in max bytes.
+// If even the lowest quality is too big, the image is downscaled.
+func sizedJPEG(img image.Image, max int, settings exportSettings) ([]byte, int, error) {
+	for {
+		var best []byte
+		quality := -1
+
+		// binary search for the highest quality that fits
+		lo, hi := 0, len(jpegQualities)-1
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			data, err := encodeJPEG(img, mid, settings)
+			if err != nil {
+				return nil, 0, err
+			}
+			if len(data) <= max {
+				best, quality = data, mid
+				lo = mid + 1
+			} else {
+				hi = mid - 1
+				if mid == 0 {
+					best = data
+				}
+			}
+		}
+		if quality >= 0 {
+			return best, quality, nil
+		}
+
+		// downscale, assuming size is proportional to pixel count
+		size := img.Bounds().Size()
+		scale := 0.9 * math.Sqrt(float64(max)/float64(len(best)))
+		width := uint(float64(size.X) * scale)
+		height := uint(float64(size.Y) * scale)
+		if width < 80 || height < 80 {
+			return nil, 0, errors.New("can't fit JPEG in the maximum file size")
+		}
+		img = resize.Thumbnail(width, height, img, resize.Lanczos2)
+	}
+}
+
+// reencodeJPEG re-encodes a JPEG, applying export settings, at the requested quality.
+// Orientation is applied, so the EXIF orientation must be reset.
+func reencodeJPEG(data []byte, settings exportSettings) ([]byte, error) {
+	img, err := exportImage(data, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeJPEG(img, settings.quality(), settings)
+}
+
+// decodeJPEG decodes a JPEG, and applies its EXIF orientation.
+func decodeJPEG(data []byte) (image.Image, error) {
 	img, err := jpeg.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 
 	exf := rotateflip.Orientation(exifOrientation(data))
-	img = rotateflip.Image(img, exf.Op())
-	fit := settings.FitImage(img.Bounds().Size())
-	img = resize.Thumbnail(uint(fit.X), uint(fit.Y), img, resize.Lanczos2)
+	return rotateflip.Image(img, exf.Op()), nil
+}
+
+// exportImage decodes a JPEG, and applies export settings:
+// resampling and output sharpening (if requested), and watermark.
+func exportImage(data []byte, settings exportSettings) (image.Image, error) {
+	img, err := decodeJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.Resample {
+		fit := settings.FitImage(img.Bounds().Size())
+		img = resize.Thumbnail(uint(fit.X), uint(fit.Y), img, resize.Lanczos2)
+		img = sharpenImage(img, settings)
+	}
+
+	if settings.Watermark != "" {
+		img, err = applyWatermark(img, settings.Watermark)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return img, nil
+}
+
+// clippingJPEG paints clipped highlights red, and clipped shadows blue.
+// Highlights clip if any channel is blown; shadows only if all channels are black,
+// so that saturated colors aren't flagged.
+func clippingJPEG(data []byte) ([]byte, error) {
+	img, err := decodeJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// JPEG artifacts make exact 0/255 unreliable, allow for 1 level of slack
+	const highlights, shadows = 254, 1
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		r, g, b := rgba.Pix[i+0], rgba.Pix[i+1], rgba.Pix[i+2]
+		switch {
+		case r >= highlights || g >= highlights || b >= highlights:
+			rgba.Pix[i+0], rgba.Pix[i+1], rgba.Pix[i+2] = 255, 0, 0
+		case r <= shadows && g <= shadows && b <= shadows:
+			rgba.Pix[i+0], rgba.Pix[i+1], rgba.Pix[i+2] = 0, 0, 255
+		}
+	}
 
 	buf := bytes.Buffer{}
-	// https://fotoforensics.com/tutorial.php?tt=estq
-	opt := jpeg.Options{Quality: [13]int{30, 34, 47, 62, 69, 76, 79, 82, 86, 90, 93, 97, 99}[settings.Quality]}
-	if err := jpeg.Encode(&buf, img, &opt); err != nil {
+	if err := jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: 90}); err != nil {
 		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	return append(jfifHeader(settings), buf.Bytes()[2:]...), nil
+// medianLuminance returns the median relative luminance (linear, 0 to 1)
+// of an sRGB JPEG.
+func medianLuminance(data []byte) (float64, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+
+	var linear [256]float64
+	for i := range linear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			linear[i] = v / 12.92
+		} else {
+			linear[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+
+	const bins = 1 << 16
+	var hist [bins]int
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		y := 0.2126*linear[rgba.Pix[i+0]] +
+			0.7152*linear[rgba.Pix[i+1]] +
+			0.0722*linear[rgba.Pix[i+2]]
+		hist[int(y*(bins-1)+0.5)]++
+	}
+
+	half := len(rgba.Pix) / 4 / 2
+	for i, n := range hist {
+		if half -= n; half < 0 {
+			return float64(i) / (bins - 1), nil
+		}
+	}
+	return 0, errors.New("empty image")
 }
 
 func exifOrientation(data []byte) int {
@@ -150,8 +325,66 @@ func exifOrientation(data []byte) int {
 	return -2
 }
 
+// The sum of the IJG standard luminance quantization table, at quality 50.
+const jpegLuminanceSum = 16 + 11 + 10 + 16 + 24 + 40 + 51 + 61 +
+	12 + 12 + 14 + 19 + 26 + 58 + 60 + 55 +
+	14 + 13 + 16 + 24 + 40 + 57 + 69 + 56 +
+	14 + 17 + 22 + 29 + 51 + 87 + 80 + 62 +
+	18 + 22 + 37 + 56 + 68 + 109 + 103 + 77 +
+	24 + 35 + 55 + 64 + 81 + 104 + 113 + 92 +
+	49 + 64 + 78 + 87 + 103 + 121 + 120 + 101 +
+	72 + 92 + 95 + 98 + 112 + 100 + 103 + 99
+
+// jpegQuality estimates the IJG quality (1-100) of a JPEG,
+// by comparing its luminance quantization table to the standard one.
+// It returns 0 if the JPEG has no luminance table.
+func jpegQuality(data []byte) int {
+	if !bytes.HasPrefix(data, []byte("\xff\xd8")) {
+		return 0
+	}
+
+	data = data[2:]
+	for len(data) >= 4 {
+		marker := binary.BigEndian.Uint16(data)
+		size := int(binary.BigEndian.Uint16(data[2:])) + 2
+		if marker < 0xffc0 || marker == 0xffda || size < 4 || size > len(data) {
+			return 0 // not a marker, or start of scan
+		}
+
+		if marker == 0xffdb { // DQT
+			tables := data[4:size]
+			for len(tables) > 0 {
+				precision, id := int(tables[0]>>4), tables[0]&15
+				n := 64 * (precision + 1)
+				if len(tables) < 1+n {
+					return 0
+				}
+				if id == 0 {
+					var sum int
+					for i := 0; i < 64; i++ {
+						if precision == 0 {
+							sum += int(tables[1+i])
+						} else {
+							sum += int(binary.BigEndian.Uint16(tables[1+2*i:]))
+						}
+					}
+					// invert the IJG scaling of the standard table
+					scale := 100 * float64(sum) / jpegLuminanceSum
+					if scale <= 100 {
+						return int(math.Round((200 - scale) / 2))
+					}
+					return max(1, int(math.Round(5000/scale)))
+				}
+				tables = tables[1+n:]
+			}
+		}
+		data = data[size:]
+	}
+	return 0
+}
+
 func jfifHeader(settings exportSettings) []byte {
-	if settings.DimUnit == "px" {
+	if !settings.Resample || !settings.hasDensity() {
 		return []byte{'\xff', '\xd8'}
 	}
 
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+func Test_sizedJPEG(t *testing.T) {
+	// noisy, so that it compresses poorly
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+	}
+
+	settings := exportSettings{DimUnit: "px"}
+	max, err := encodeJPEG(img, len(jpegQualities)-1, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int{len(max), len(max) / 2, len(max) / 50} {
+		data, quality, err := sizedJPEG(img, limit, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > limit {
+			t.Errorf("sizedJPEG(%d) = %d bytes", limit, len(data))
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if limit == len(max) && quality != len(jpegQualities)-1 {
+			t.Errorf("sizedJPEG(%d) quality = %d", limit, quality)
+		}
+	}
+
+	if _, _, err := sizedJPEG(img, 100, settings); err == nil {
+		t.Error("sizedJPEG(100) should fail")
+	}
+}
+
+func Test_resampleJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 600, 400)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		settings exportSettings
+		want     image.Point
+		density  bool
+	}{
+		{exportSettings{Resample: true, Quality: 10, Fit: "dims", Long: 300, DimUnit: "px"}, image.Pt(300, 200), false},
+		{exportSettings{Resample: true, Quality: 10, Fit: "size", Width: 1, Height: 1, DimUnit: "in", Density: 100, DenUnit: "ppi"}, image.Pt(100, 66), true},
+		{exportSettings{Resample: true, Quality: 10, Fit: "mpix", MPixels: 0.06}, image.Pt(300, 200), false},
+	}
+	for _, tt := range tests {
+		data, err := resampleJPEG(buf.Bytes(), tt.settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := image.Pt(cfg.Width, cfg.Height); got != tt.want {
+			t.Errorf("resampleJPEG(%v) = %v, want %v", tt.settings, got, tt.want)
+		}
+		if got := bytes.HasPrefix(data[2:], []byte("\xff\xe0\x00\x10JFIF")); got != tt.density {
+			t.Errorf("resampleJPEG(%v) JFIF = %v, want %v", tt.settings, got, tt.density)
+		}
+	}
+
+	if _, err := resampleJPEG(buf.Bytes(), exportSettings{Resample: true, Quality: 13}); err == nil {
+		t.Error("resampleJPEG(quality 13) should fail")
+	}
+}
+
+func Test_jpegQuality(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for _, quality := range append(jpegQualities[:], 50, 100) {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		if got := jpegQuality(buf.Bytes()); got < quality-1 || got > quality+1 {
+			t.Errorf("jpegQuality(%d) = %d", quality, got)
+		}
+	}
+	if got := jpegQuality([]byte("\xff\xd8\xff\xda\x00\x02")); got != 0 {
+		t.Errorf("jpegQuality(no tables) = %d, want 0", got)
+	}
+}
+
+func Test_reencodeJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 60, 40)), &jpeg.Options{Quality: 99}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		settings exportSettings
+		want     int
+	}{
+		{exportSettings{}, jpegQualities[10]},
+		{exportSettings{Quality: 3}, jpegQualities[3]},
+		{exportSettings{Quality: 12}, jpegQualities[12]},
+	}
+	for _, tt := range tests {
+		data, err := reencodeJPEG(buf.Bytes(), tt.settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := jpegQuality(data); got < tt.want-1 || got > tt.want+1 {
+			t.Errorf("reencodeJPEG(%+v) quality = %d, want %d", tt.settings, got, tt.want)
+		}
+	}
+}
+
+func Test_clippingJPEG(t *testing.T) {
+	colors := []color.RGBA{
+		{255, 255, 255, 255}, // clipped highlights
+		{0, 0, 0, 255},       // clipped shadows
+		{200, 0, 0, 255},     // saturated, not clipped
+		{128, 128, 128, 255}, // not clipped
+	}
+	want := []color.RGBA{
+		{255, 0, 0, 255},
+		{0, 0, 255, 255},
+		{200, 0, 0, 255},
+		{128, 128, 128, 255},
+	}
+
+	// uniform 16x16 blocks, so that JPEG artifacts are small
+	img := image.NewRGBA(image.Rect(0, 0, 16*len(colors), 16))
+	for i, c := range colors {
+		draw.Draw(img, image.Rect(16*i, 0, 16*i+16, 16), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := clippingJPEG(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	near := func(a, b uint8) bool { return a-b < 24 || b-a < 24 }
+	for i, w := range want {
+		r, g, b, _ := out.At(16*i+8, 8).RGBA()
+		if !near(uint8(r>>8), w.R) || !near(uint8(g>>8), w.G) || !near(uint8(b>>8), w.B) {
+			t.Errorf("clippingJPEG() block %d = (%d, %d, %d), want %v", i, r>>8, g>>8, b>>8, w)
+		}
+	}
+}
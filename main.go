@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/ncruces/rethinkraw/internal/config"
@@ -41,6 +42,12 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if _, ok := commands[os.Args[1]]; ok {
+			os.Exit(runCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
 	err := run()
 	if err != nil {
 		log.Fatal(err)
@@ -56,14 +63,18 @@ func run() error {
 	pass := flag.String("password", "$PASSWORD", "the password used to authenticate to the server (required)")
 	cert := flag.String("certfile", "", "the PEM encoded certificate `file`")
 	key := flag.String("keyfile", "", "the PEM encoded private key `file`")
+	nproc := flag.Int("workers", 0, "the maximum `number` of photos processed in parallel (default: the number of CPUs)")
 	flag.Usage = func() {
 		w := flag.CommandLine.Output()
 		fmt.Fprintf(w, "usage: %s [OPTION]... DIRECTORY\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(w, "       %s COMMAND [OPTION]... PATH...\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
+		fmt.Fprintf(w, "commands: %s (see COMMAND -h)\n", strings.Join(commandNames(), ", "))
 	}
 	const unspecified = "\x00"
 	*pass = unspecified
 	flag.Parse()
+	setupWorkers(*nproc)
 
 	if runtime.GOOS != "windows" && runtime.GOOS != "darwin" {
 		wine.Startup()
@@ -139,10 +150,14 @@ func run() error {
 		}
 		defer func() {
 			http.Shutdown(context.Background())
+			stopJobs()
 			exif.Shutdown()
 			wine.Shutdown()
 			os.RemoveAll(config.TempDir)
 		}()
+		if err := resumeJobs(); err != nil {
+			log.Print(err)
+		}
 		go http.Serve(ln)
 	} else if config.ServerMode {
 		return err
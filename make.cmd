@@ -41,6 +41,9 @@ IF NOT EXIST assets\fontawesome.css (
 IF [%1]==[test] (
     ECHO Run tests...
     go test .\...
+    ECHO Vet other platforms...
+    FOR %%o IN (linux darwin) DO SET "GOOS=%%o" && go vet .\...
+    SET "GOOS="
 ) ELSE IF [%1]==[run] (
     ECHO Run app...
     go build -race -o %tgt%\RethinkRAW.exe && %tgt%\RethinkRAW.exe
@@ -50,6 +50,8 @@ fi
 if [[ "$1" == test ]]; then
     echo Run tests...
     go test ./...
+    echo Vet other platforms...
+    for os in windows linux; do GOOS=$os go vet ./...; done
 elif [[ "$1" == run ]]; then
     echo Run app...
     go build -race -o "$tgt/MacOS/rethinkraw" && shift && exec "$tgt/MacOS/rethinkraw" "$@"
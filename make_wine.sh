@@ -42,6 +42,8 @@ fi
 if [[ "$1" == test ]]; then
     echo Run tests...
     go test ./...
+    echo Vet other platforms...
+    for os in windows darwin; do GOOS=$os go vet ./...; done
 elif [[ "$1" == run ]]; then
     echo Run app...
     go build -race -o "$tgt/rethinkraw" && shift && exec "$tgt/rethinkraw" "$@"
@@ -3,8 +3,12 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"log"
+	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/ncruces/go-exiftool"
 )
@@ -16,12 +20,21 @@ func setupExifTool() (s *exiftool.Server, err error) {
 	return exifserver, err
 }
 
-func getMetaHTML(path string) ([]byte, error) {
+// exifCommand runs an exiftool command, scheduled by priority.
+func exifCommand(ctx context.Context, args ...string) ([]byte, error) {
+	if err := schedExifTool.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer schedExifTool.release(ctx)
+	return exifserver.Command(args...)
+}
+
+func getMetaHTML(ctx context.Context, path string) ([]byte, error) {
 	log.Print("exiftool (get meta)...")
-	return exifserver.Command("-htmlFormat", "-groupHeadings", "-long", "-fixBase", path)
+	return exifCommand(ctx, "-htmlFormat", "-groupHeadings", "-long", "-fixBase", path)
 }
 
-func fixMetaDNG(orig, dest, name string) error {
+func fixMetaDNG(ctx context.Context, orig, dest, name, policy string) error {
 	opts := []string{"-tagsFromFile", orig, "-fixBase",
 		"-MakerNotes", "-OriginalRawFileName-=" + filepath.Base(orig)}
 	if name != "" {
@@ -30,11 +43,14 @@ func fixMetaDNG(orig, dest, name string) error {
 	opts = append(opts, "-overwrite_original", dest)
 
 	log.Print("exiftool (fix dng)...")
-	_, err := exifserver.Command(opts...)
-	return err
+	_, err := exifCommand(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	return applyMetaPolicy(ctx, dest, policy)
 }
 
-func injectXMP(orig, dest string) error {
+func injectXMP(ctx context.Context, orig, dest string) error {
 	// opts := []string{"-tagsFromFile", orig, "-fixBase",
 	// 	"-CommonIFD0", "-ExifIFD:all", "-GPS:all", // https://exiftool.org/forum/index.php?topic=8378.msg43043#msg43043
 	// 	"-IPTC:all", "-XMP-dc:all", "-XMP-dc:Format=",
@@ -51,37 +67,119 @@ func injectXMP(orig, dest string) error {
 		dest}
 
 	log.Print("exiftool (inject xmp)...")
-	_, err := exifserver.Command(opts...)
+	_, err := exifCommand(ctx, opts...)
 	return err
 }
 
-func fixMetaJPEG(orig, dest string) error {
-	opts := []string{"-tagsFromFile", orig,
-		"-fixBase",
-		"-CommonIFD0",
-		"-ExifIFD:all",
-		"-GPS:all", // https://exiftool.org/forum/index.php?topic=8378.msg43043#msg43043
-		"-IPTC:all",
-		"-XMP-dc:all",
-		"-XMP-dc:Format=",
-		"-fast",
-		"-overwrite_original",
-		dest}
+// fixMetaArgs are the tags copied into exported JPEG, TIFF and PNG files.
+var fixMetaArgs = []string{
+	"-fixBase",
+	"-CommonIFD0",
+	"-ExifIFD:all",
+	"-GPS:all", // https://exiftool.org/forum/index.php?topic=8378.msg43043#msg43043
+	"-IPTC:all",
+	"-XMP-dc:all",
+	"-XMP-dc:Format=",
+	"-XMP-xmpRights:all",
+	"-XMP-iptcCore:all",
+	"-XMP-photoshop:City",
+	"-XMP-photoshop:State",
+	"-XMP-photoshop:Country",
+	"-XMP-photoshop:Credit",
+}
+
+// fixMeta copies metadata from orig into an exported JPEG, TIFF or PNG,
+// and applies a metadata policy.
+// Only JPEGs can be written fast, as their metadata comes before the image.
+func fixMeta(ctx context.Context, orig, dest, policy string, fast bool) error {
+	opts := append([]string{"-tagsFromFile", orig}, fixMetaArgs...)
+	if fast {
+		opts = append(opts, "-fast")
+	}
+	opts = append(opts, "-overwrite_original", dest)
+
+	log.Print("exiftool (fix meta)...")
+	_, err := exifCommand(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	return applyMetaPolicy(ctx, dest, policy)
+}
+
+// Metadata policies for exports.
+//
+//	all        keep all metadata (default)
+//	copyright  keep only copyright and contact information
+//	nogps      keep all metadata, except location and serial numbers
+//	none       strip all metadata
+//
+// Metadata required to render the file (orientation, color profile,
+// Camera Raw settings, DNG tags) is always kept.
+var metaPolicies = map[string][]string{
+	"all":       nil,
+	"copyright": append(stripMetaArgs[:len(stripMetaArgs):len(stripMetaArgs)], copyrightMetaArgs...),
+	"nogps":     {"-GPS:all=", "-XMP:GPS*=", "-SerialNumber=", "-InternalSerialNumber=", "-LensSerialNumber="},
+	"none":      stripMetaArgs,
+}
+
+var stripMetaArgs = []string{
+	"-XMP:all=", "-IPTC:all=", "-Photoshop:all=", "-GPS:all=", "-ExifIFD:all=", "-MakerNotes:all=",
+	"-IFD0:Artist=", "-IFD0:Copyright=", "-IFD0:ImageDescription=",
+	"-IFD0:Software=", "-IFD0:HostComputer=", "-IFD0:ModifyDate=",
+	"-tagsFromFile", "@", "-XMP-crs:all",
+}
+
+var copyrightMetaArgs = []string{
+	"-IFD0:Artist", "-IFD0:Copyright",
+	"-XMP-dc:Creator", "-XMP-dc:Rights", "-XMP-xmpRights:all",
+	"-XMP-photoshop:Credit", "-XMP-iptcCore:CreatorContactInfo",
+	"-IPTC:By-line", "-IPTC:CopyrightNotice", "-IPTC:Credit", "-IPTC:Contact",
+}
+
+func applyMetaPolicy(ctx context.Context, dest, policy string) error {
+	if policy == "" {
+		policy = "all"
+	}
+	args, ok := metaPolicies[policy]
+	if !ok {
+		return errors.New("unknown metadata policy: " + strconv.Quote(policy))
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	opts := append(args[:len(args):len(args)], "-overwrite_original", dest)
+	log.Print("exiftool (metadata policy)...")
+	_, err := exifCommand(ctx, opts...)
+	return err
+}
+
+func resetOrientation(ctx context.Context, path string) error {
+	log.Print("exiftool (reset orientation)...")
+	_, err := exifCommand(ctx, "-Orientation#=1", "-overwrite_original", path)
+	return err
+}
+
+func embedICC(ctx context.Context, dest string, cs *colorSpace, icc string) error {
+	err := os.WriteFile(icc, cs.iccProfile(), 0600)
+	if err != nil {
+		return err
+	}
 
-	log.Print("exiftool (fix jpeg)...")
-	_, err := exifserver.Command(opts...)
+	log.Print("exiftool (embed icc)...")
+	_, err = exifCommand(ctx, "-ICC_Profile<="+icc, "-overwrite_original", dest)
 	return err
 }
 
-func dngHasEdits(path string) bool {
+func dngHasEdits(ctx context.Context, path string) bool {
 	log.Print("exiftool (has edits?)...")
-	out, err := exifserver.Command("-XMP-photoshop:all", path)
+	out, err := exifCommand(ctx, "-XMP-photoshop:all", path)
 	return err == nil && len(out) > 0
 }
 
-func cameraMatchingWhiteBalance(path string) string {
+func cameraMatchingWhiteBalance(ctx context.Context, path string) string {
 	log.Print("exiftool (get camera matching white balance)...")
-	out, err := exifserver.Command("-duplicates", "-short3", "-fast", "-ExifIFD:WhiteBalance", "-MakerNotes:WhiteBalance", path)
+	out, err := exifCommand(ctx, "-duplicates", "-short3", "-fast", "-ExifIFD:WhiteBalance", "-MakerNotes:WhiteBalance", path)
 	if err != nil {
 		return ""
 	}
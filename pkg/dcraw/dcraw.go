@@ -24,6 +24,7 @@ import (
 	"io/fs"
 	"os"
 	"regexp"
+	"runtime"
 	"strconv"
 	"sync"
 
@@ -39,6 +40,8 @@ import (
 var (
 	Binary []byte // Binary to execute.
 	Path   string // Path to load the binary from.
+
+	Concurrency int // Maximum concurrent runs (default: number of CPUs).
 )
 
 var (
@@ -70,7 +73,11 @@ func compile() {
 		module = m
 	}
 
-	sem = semaphore.NewWeighted(6)
+	if Concurrency > 0 {
+		sem = semaphore.NewWeighted(int64(Concurrency))
+	} else {
+		sem = semaphore.NewWeighted(int64(runtime.NumCPU()))
+	}
 	orienRegex = regexp.MustCompile(`Orientation: +(\d)`)
 	thumbRegex = regexp.MustCompile(`Thumb size: +(\d+) x (\d+)`)
 }
@@ -211,6 +218,51 @@ func GetRAWPixels(ctx context.Context, r io.ReadSeeker) ([]byte, error) {
 		readerFSname)
 }
 
+// ColorSpace is an output color space for dcraw.
+type ColorSpace int
+
+// Output color spaces.
+const (
+	SRGB ColorSpace = iota + 1
+	AdobeRGB
+	WideGamutRGB
+	ProPhotoRGB
+	XYZ
+	ACES
+)
+
+// DevelopOptions configure how [Develop] develops a RAW file.
+type DevelopOptions struct {
+	AutoWB     bool       // Use automatic white balance, instead of the camera's.
+	Brightness float64    // Scale brightness by this factor (0 means 1.0).
+	ColorSpace ColorSpace // Output color space (0 means sRGB).
+	Gamma      [2]float64 // Gamma curve power and toe slope (0 means BT.709).
+}
+
+// Develop develops a full resolution, oriented, 16-bit image from the RAW file.
+//
+// The image is a PNM file in 16-bit P6 format (big-endian samples).
+func Develop(ctx context.Context, r io.ReadSeeker, opts DevelopOptions) ([]byte, error) {
+	if opts.ColorSpace == 0 {
+		opts.ColorSpace = SRGB
+	}
+	args := []string{"dcraw", "-6", "-c", "-o", strconv.Itoa(int(opts.ColorSpace))}
+	if opts.Gamma[0] > 0 {
+		args = append(args, "-g",
+			strconv.FormatFloat(opts.Gamma[0], 'f', -1, 64),
+			strconv.FormatFloat(opts.Gamma[1], 'f', -1, 64))
+	}
+	if opts.AutoWB {
+		args = append(args, "-a")
+	} else {
+		args = append(args, "-w")
+	}
+	if opts.Brightness > 0 {
+		args = append(args, "-b", strconv.FormatFloat(opts.Brightness, 'f', -1, 64))
+	}
+	return run(ctx, readerFS{r}, append(args, readerFSname)...)
+}
+
 func pnmDecodeThumb(data []byte) (image.Image, error) {
 	var format, width, height int
 	n, _ := fmt.Fscanf(bytes.NewReader(data), "P%d\n%d %d\n255\n", &format, &width, &height)
@@ -10,16 +10,26 @@
 package dngconv
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sync"
+
+	"github.com/ncruces/rethinkraw/pkg/osutil"
 )
 
 var Path string
 
-var once sync.Once
+var (
+	once        sync.Once
+	versionOnce sync.Once
+	version     string
+	versionErr  error
+)
 
 // IsInstalled checks if Adobe DNG Converter is installed.
 // If true, [Path] will be set to the converter's executable path.
@@ -28,6 +38,31 @@ func IsInstalled() bool {
 	return Path != ""
 }
 
+// Version returns the version of Adobe DNG Converter, e.g. "16.0.0.123".
+func Version() (string, error) {
+	if !IsInstalled() {
+		return "", errors.New("dng converter: not installed")
+	}
+	versionOnce.Do(func() { version, versionErr = getVersion() })
+	return version, versionErr
+}
+
+// A StartError means Adobe DNG Converter couldn't be started,
+// e.g. because Wine failed to start; unlike a failed conversion, retrying may succeed.
+type StartError struct{ Err error }
+
+func (e *StartError) Error() string { return e.Err.Error() }
+func (e *StartError) Unwrap() error { return e.Err }
+
+type priorityKey struct{}
+
+// WithPriority returns a context that runs Adobe DNG Converter
+// with the given scheduling priority, e.g. to keep batch work
+// from slowing down interactive work.
+func WithPriority(ctx context.Context, prio osutil.PriorityClass) context.Context {
+	return context.WithValue(ctx, priorityKey{}, prio)
+}
+
 // Convert converts an input RAW file into an output DNG using Adobe DNG Converter.
 func Convert(ctx context.Context, input, output string, args ...string) error {
 	once.Do(findConverter)
@@ -54,3 +89,25 @@ func Convert(ctx context.Context, input, output string, args ...string) error {
 	}
 	return nil
 }
+
+// run runs cmd like [exec.Cmd.Output], discarding stdout,
+// with the priority set by [WithPriority].
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	if prio, ok := ctx.Value(priorityKey{}).(osutil.PriorityClass); ok {
+		osutil.SetPriority(cmd.Process, prio) // best effort
+	}
+
+	err = cmd.Wait()
+	var eerr *exec.ExitError
+	if errors.As(err, &eerr) {
+		eerr.Stderr = stderr.Bytes()
+	}
+	return err
+}
@@ -2,8 +2,11 @@ package dngconv
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 )
 
 func findConverter() {
@@ -15,9 +18,23 @@ func findConverter() {
 	Path = converter
 }
 
+var versionRE = regexp.MustCompile(`<key>CFBundleShortVersionString</key>\s*<string>([^<]+)</string>`)
+
+// getVersion reads the version from the converter's app bundle.
+func getVersion() (string, error) {
+	plist, err := os.ReadFile(filepath.Join(filepath.Dir(Path), "../Info.plist"))
+	if err != nil {
+		return "", err
+	}
+	m := versionRE.FindSubmatch(plist)
+	if m == nil {
+		return "", errors.New("dng converter: no version information")
+	}
+	return string(m[1]), nil
+}
+
 func runConverter(ctx context.Context, args ...string) error {
-	_, err := exec.CommandContext(ctx, Path, args...).Output()
-	return err
+	return run(ctx, exec.CommandContext(ctx, Path, args...))
 }
 
 func dngPath(path string) (string, error) {
@@ -0,0 +1,39 @@
+//go:build !darwin
+
+package dngconv
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// getVersion reads the file version from the converter's version resource.
+func getVersion() (string, error) {
+	f, err := pe.Open(Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	rsrc := f.Section(".rsrc")
+	if rsrc == nil {
+		return "", errors.New("dng converter: no version information")
+	}
+	data, err := rsrc.Data()
+	if err != nil {
+		return "", err
+	}
+
+	// VS_FIXEDFILEINFO starts with its signature, and its structure version,
+	// followed by the most and least significant halves of the file version.
+	le := binary.LittleEndian
+	i := bytes.Index(data, []byte{0xbd, 0x04, 0xef, 0xfe})
+	if i < 0 || len(data) < i+16 {
+		return "", errors.New("dng converter: no version information")
+	}
+	ms, ls := le.Uint32(data[i+8:]), le.Uint32(data[i+12:])
+	return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xffff, ls>>16, ls&0xffff), nil
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package dngconv
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/ncruces/rethinkraw/pkg/osutil"
+)
+
+func Test_run(t *testing.T) {
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip(err)
+	}
+
+	// nice prints its niceness on stdout, redirect to stderr
+	ctx := WithPriority(context.Background(), osutil.BelowNormal)
+	err := run(ctx, exec.Command("sh", "-c", "sleep 0.1; nice >&2; exit 1"))
+
+	var eerr *exec.ExitError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("run() = %v", err)
+	}
+	if got := strings.TrimSpace(string(eerr.Stderr)); got != "8" {
+		t.Errorf("run() niceness = %q, want 8", got)
+	}
+}
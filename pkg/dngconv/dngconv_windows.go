@@ -28,8 +28,7 @@ func findConverter() {
 }
 
 func runConverter(ctx context.Context, args ...string) error {
-	_, err := exec.CommandContext(ctx, Path, args...).Output()
-	return err
+	return run(ctx, exec.CommandContext(ctx, Path, args...))
 }
 
 func dngPath(path string) (string, error) {
@@ -4,7 +4,9 @@ package dngconv
 
 import (
 	"context"
+	"errors"
 	"os"
+	"os/exec"
 
 	"github.com/ncruces/rethinkraw/pkg/wine"
 )
@@ -36,7 +38,11 @@ func findConverter() {
 }
 
 func runConverter(ctx context.Context, args ...string) error {
-	_, err := wine.CommandContext(ctx, Path, args...).Output()
+	err := run(ctx, wine.CommandContext(ctx, Path, args...))
+	var eerr *exec.ExitError
+	if err != nil && !errors.As(err, &eerr) {
+		return &StartError{err}
+	}
 	return err
 }
 
@@ -50,7 +56,7 @@ func dngPath(path string) (string, error) {
 
 	p, err := wine.ToWindows(path)
 	if err != nil {
-		return "", err
+		return "", &StartError{err}
 	}
 
 	if len(dngPathCache) > 100 {
@@ -20,13 +20,8 @@ func NewFile(name string) (*os.File, error) {
 	for {
 		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
 		if errors.Is(err, fs.ErrExist) {
-			m := newFilenameRE.FindStringSubmatch(name)
-			if m != nil {
-				var i = 0
-				if m[2] != "" {
-					i, _ = strconv.Atoi(m[2])
-				}
-				name = m[1] + " (" + strconv.Itoa(i+1) + ")" + m[3]
+			if next, ok := nextFilename(name); ok {
+				name = next
 				continue
 			}
 		}
@@ -34,6 +29,37 @@ func NewFile(name string) (*os.File, error) {
 	}
 }
 
+// NewFilename returns the name NewFile would use for a new file,
+// without creating it.
+func NewFilename(name string) (string, error) {
+	for {
+		_, err := os.Lstat(name)
+		if err == nil {
+			if next, ok := nextFilename(name); ok {
+				name = next
+				continue
+			}
+			return "", fs.ErrExist
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return name, nil
+		}
+		return "", err
+	}
+}
+
+func nextFilename(name string) (string, bool) {
+	m := newFilenameRE.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	var i = 0
+	if m[2] != "" {
+		i, _ = strconv.Atoi(m[2])
+	}
+	return m[1] + " (" + strconv.Itoa(i+1) + ")" + m[3], true
+}
+
 // Copy copies src to dst.
 func Copy(src, dst string) (err error) {
 	in, err := os.Open(src)
@@ -9,5 +9,6 @@ var (
 	wideCharToMultiByte = kernel32.NewProc("WideCharToMultiByte")
 	getConsoleWindow    = kernel32.NewProc("GetConsoleWindow")
 	attachConsole       = kernel32.NewProc("AttachConsole")
+	globalMemoryStatus  = kernel32.NewProc("GlobalMemoryStatusEx")
 	setForegroundWindow = user32.NewProc("SetForegroundWindow")
 )
@@ -0,0 +1,13 @@
+package osutil
+
+// FreeMemory returns the physical memory available, in bytes,
+// or zero if unknown.
+func FreeMemory() uint64 {
+	return freeMemory()
+}
+
+// FreeSpace returns the disk space available to the user at path, in bytes,
+// or zero if unknown.
+func FreeSpace(path string) uint64 {
+	return freeSpace(path)
+}
@@ -0,0 +1,30 @@
+package osutil
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func freeMemory() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		const prefix = "MemAvailable:"
+		if line := scan.Text(); strings.HasPrefix(line, prefix) {
+			line = strings.TrimSuffix(strings.TrimSpace(line[len(prefix):]), " kB")
+			kb, err := strconv.ParseUint(line, 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+
+package osutil
+
+func freeMemory() uint64 {
+	return 0
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package osutil
+
+import "syscall"
+
+func freeSpace(path string) uint64 {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize)
+}
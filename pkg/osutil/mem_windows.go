@@ -0,0 +1,41 @@
+package osutil
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/api/sysinfoapi/ns-sysinfoapi-memorystatusex
+type memoryStatusEx struct {
+	length               uint32
+	memoryLoad           uint32
+	totalPhys            uint64
+	availPhys            uint64
+	totalPageFile        uint64
+	availPageFile        uint64
+	totalVirtual         uint64
+	availVirtual         uint64
+	availExtendedVirtual uint64
+}
+
+func freeMemory() uint64 {
+	var st memoryStatusEx
+	st.length = uint32(unsafe.Sizeof(st))
+	if r, _, _ := globalMemoryStatus.Call(uintptr(unsafe.Pointer(&st))); r == 0 {
+		return 0
+	}
+	return st.availPhys
+}
+
+func freeSpace(path string) uint64 {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0
+	}
+	var avail uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &avail, nil, nil); err != nil {
+		return 0
+	}
+	return avail
+}
@@ -14,6 +14,6 @@ const (
 )
 
 // SetPriority sets the scheduling priority of proc.
-func SetPriority(proc os.Process, prio PriorityClass) error {
+func SetPriority(proc *os.Process, prio PriorityClass) error {
 	return setPriority(proc, prio)
 }
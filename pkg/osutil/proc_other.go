@@ -7,6 +7,6 @@ import (
 	"syscall"
 )
 
-func setPriority(proc os.Process, prio PriorityClass) error {
+func setPriority(proc *os.Process, prio PriorityClass) error {
 	return syscall.Setpriority(syscall.PRIO_PROCESS, proc.Pid, int(prio))
 }
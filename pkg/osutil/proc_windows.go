@@ -6,7 +6,7 @@ import (
 	"golang.org/x/sys/windows"
 )
 
-func setPriority(proc os.Process, prio PriorityClass) error {
+func setPriority(proc *os.Process, prio PriorityClass) error {
 	const da = windows.PROCESS_SET_INFORMATION
 	h, err := windows.OpenProcess(da, false, uint32(proc.Pid))
 	if err != nil {
@@ -0,0 +1,202 @@
+// Package pdf writes simple PDF documents, made of JPEG images and text.
+//
+// Text uses the standard Helvetica font, with WinAnsi encoding,
+// so only Latin-1 characters are supported; others are replaced by '?'.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strings"
+)
+
+// Document is a PDF document.
+type Document struct {
+	pages []*Page
+}
+
+// Page is a page of a PDF document.
+// Coordinates are in points (1/72 inch), from the bottom-left corner.
+type Page struct {
+	width, height float64
+	content       bytes.Buffer
+	images        [][]byte
+	infos         []imageInfo
+}
+
+type imageInfo struct {
+	width, height int
+	colorSpace    string
+}
+
+// AddPage adds a new page with the given size, in points.
+func (d *Document) AddPage(width, height float64) *Page {
+	page := &Page{width: width, height: height}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Image draws a JPEG image, scaled to fit the rectangle at x, y.
+func (p *Page) Image(data []byte, x, y, width, height float64) error {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	var info imageInfo
+	info.width, info.height = cfg.Width, cfg.Height
+	switch cfg.ColorModel {
+	case color.GrayModel:
+		info.colorSpace = "/DeviceGray"
+	case color.CMYKModel:
+		info.colorSpace = "/DeviceCMYK"
+	default:
+		info.colorSpace = "/DeviceRGB"
+	}
+
+	p.images = append(p.images, data)
+	p.infos = append(p.infos, info)
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n",
+		num(width), num(height), num(x), num(y), len(p.images))
+	return nil
+}
+
+// Text draws a line of text, with its baseline starting at x, y.
+func (p *Page) Text(x, y, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /F1 %s Tf %s %s Td (%s) Tj ET\n",
+		num(size), num(x), num(y), escape(text))
+}
+
+// TextWidth returns the width of a line of text, in points.
+func TextWidth(text string, size float64) float64 {
+	var w int
+	for _, r := range text {
+		if ' ' <= r && r <= '~' {
+			w += helveticaWidths[r-' ']
+		} else {
+			w += 556
+		}
+	}
+	return float64(w) * size / 1000
+}
+
+// WriteTo writes the document to w.
+func (d *Document) WriteTo(w io.Writer) (n int64, err error) {
+	out := countWriter{w: bufio.NewWriter(w)}
+	var offsets []int64
+
+	object := func(format string, args ...any) {
+		offsets = append(offsets, out.n)
+		fmt.Fprintf(&out, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&out, format, args...)
+		fmt.Fprint(&out, "\nendobj\n")
+	}
+	stream := func(dict string, data []byte) {
+		offsets = append(offsets, out.n)
+		fmt.Fprintf(&out, "%d 0 obj\n<<%s /Length %d>>\nstream\n", len(offsets), dict, len(data))
+		out.Write(data)
+		fmt.Fprint(&out, "\nendstream\nendobj\n")
+	}
+
+	io.WriteString(&out, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// objects 1 to 3 are the catalog, the page tree, and the font;
+	// each page is followed by its content, and its images
+	var kids []string
+	next := 4
+	for _, page := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", next))
+		next += 2 + len(page.images)
+	}
+
+	object("<</Type /Catalog /Pages 2 0 R>>")
+	object("<</Type /Pages /Kids [%s] /Count %d>>", strings.Join(kids, " "), len(kids))
+	object("<</Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding>>")
+
+	for _, page := range d.pages {
+		id := len(offsets) + 1
+
+		var xobjects strings.Builder
+		for i := range page.images {
+			fmt.Fprintf(&xobjects, "/Im%d %d 0 R ", i+1, id+2+i)
+		}
+
+		object("<</Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Contents %d 0 R "+
+			"/Resources <</Font <</F1 3 0 R>> /XObject <<%s>>>>>>",
+			num(page.width), num(page.height), id+1, xobjects.String())
+		stream("", page.content.Bytes())
+		for i, data := range page.images {
+			info := page.infos[i]
+			stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d "+
+				"/ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+				info.width, info.height, info.colorSpace), data)
+		}
+	}
+
+	xref := out.n
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<</Size %d /Root 1 0 R>>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	if out.err == nil {
+		out.err = out.w.Flush()
+	}
+	return out.n, out.err
+}
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func num(f float64) string {
+	s := fmt.Sprintf("%.2f", f)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// escape encodes text as a WinAnsi PDF string.
+func escape(text string) string {
+	var buf strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(byte(r))
+		case ' ' <= r && r <= '~':
+			buf.WriteRune(r)
+		case 0xa0 <= r && r <= 0xff:
+			fmt.Fprintf(&buf, "\\%03o", r)
+		default:
+			buf.WriteByte('?')
+		}
+	}
+	return buf.String()
+}
+
+// Widths of the printable ASCII characters in Helvetica.
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
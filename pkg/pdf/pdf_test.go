@@ -0,0 +1,62 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument(t *testing.T) {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 6)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc Document
+	for i := 0; i < 2; i++ {
+		page := doc.AddPage(595, 842)
+		if err := page.Image(img.Bytes(), 36, 36, 80, 60); err != nil {
+			t.Fatal(err)
+		}
+		page.Text(36, 20, 8, "Café (1)")
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Error("missing PDF header or trailer")
+	}
+	if !bytes.Contains(data, []byte(`(Caf\351 \(1\))`)) {
+		t.Error("text not properly encoded")
+	}
+
+	// every xref offset must point to its object
+	m := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != 3+2*3 {
+		t.Fatalf("got %d objects", len(entries))
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := strconv.Itoa(i+1) + " 0 obj\n"; !bytes.HasPrefix(data[off:], []byte(want)) {
+			t.Errorf("object %d not at offset %d", i+1, off)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	if got := TextWidth("Hi", 10); got != 9.44 {
+		t.Errorf("TextWidth() = %v", got)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/png"
+)
+
+// exportPNG converts a JPEG into a PNG, applying export settings.
+func exportPNG(data []byte, settings exportSettings) ([]byte, error) {
+	img, err := exportImage(data, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if !settings.Resample {
+		return buf.Bytes(), nil
+	}
+	return physChunk(buf.Bytes(), settings), nil
+}
+
+// physChunk adds a pHYs chunk (the PNG equivalent of the JFIF density)
+// right after the IHDR chunk.
+func physChunk(data []byte, settings exportSettings) []byte {
+	if !settings.hasDensity() {
+		return data
+	}
+
+	// pixels per meter
+	var ppm uint32
+	if settings.DenUnit == "ppi" {
+		ppm = uint32(float64(settings.Density)/0.0254 + 0.5)
+	} else {
+		ppm = uint32(settings.Density * 100)
+	}
+
+	var chunk [4 + 4 + 9 + 4]byte
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:], ppm)
+	binary.BigEndian.PutUint32(chunk[12:], ppm)
+	chunk[16] = 1 // meter
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
+
+	// signature (8), IHDR length (4), type (4), data (13), CRC (4)
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	res := make([]byte, 0, len(data)+len(chunk))
+	res = append(res, data[:ihdrEnd]...)
+	res = append(res, chunk[:]...)
+	return append(res, data[ihdrEnd:]...)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func Test_physChunk(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		settings exportSettings
+		want     uint32
+	}{
+		{exportSettings{DimUnit: "in", Density: 300, DenUnit: "ppi"}, 11811},
+		{exportSettings{DimUnit: "cm", Density: 120, DenUnit: "ppc"}, 12000},
+	}
+	for _, tt := range tests {
+		data := physChunk(buf.Bytes(), tt.settings)
+		if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		i := bytes.Index(data, []byte("pHYs"))
+		if i < 0 {
+			t.Fatal("missing pHYs chunk")
+		}
+		if got := binary.BigEndian.Uint32(data[i+4:]); got != tt.want {
+			t.Errorf("physChunk(%v) = %d, want %d", tt.settings, got, tt.want)
+		}
+	}
+
+	if data := physChunk(buf.Bytes(), exportSettings{DimUnit: "px"}); !bytes.Equal(data, buf.Bytes()) {
+		t.Error("physChunk(px) changed the image")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	"github.com/gorilla/schema"
+)
+
+// Export presets are named export settings.
+
+func listPresets() ([]string, error) {
+	return listData("presets")
+}
+
+func loadPreset(name string) (exp exportSettings, err error) {
+	err = loadData("presets", name, &exp)
+	return exp, err
+}
+
+func savePreset(name string, exp exportSettings) error {
+	if err := exp.validate(); err != nil {
+		return err
+	}
+	return saveData("presets", name, exp)
+}
+
+func deletePreset(name string) error {
+	return deleteData("presets", name)
+}
+
+// decodeExportRecipes decodes the outputs of an export run:
+// a JSON list of recipes, one or more presets, or individual fields.
+func decodeExportRecipes(form url.Values) ([]exportSettings, error) {
+	if recipes := form.Get("recipes"); recipes != "" {
+		var res []exportSettings
+		if err := json.Unmarshal([]byte(recipes), &res); err != nil {
+			return nil, err
+		}
+		if len(res) == 0 {
+			return nil, errors.New("no export recipes")
+		}
+		for _, exp := range res {
+			if err := exp.validate(); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+
+	if presets := form["preset"]; len(presets) > 1 {
+		var res []exportSettings
+		for _, name := range presets {
+			exp, err := loadPreset(name)
+			if err != nil {
+				return nil, err
+			}
+			exp.Snapshot = name
+			res = append(res, exp)
+		}
+		return res, nil
+	}
+
+	exp, err := decodeExportSettings(form)
+	if err != nil {
+		return nil, err
+	}
+	return []exportSettings{exp}, nil
+}
+
+// decodeExportSettings decodes export settings from a form,
+// either from a named preset, or from individual fields.
+func decodeExportSettings(form url.Values) (exp exportSettings, err error) {
+	if name := form.Get("preset"); name != "" {
+		exp, err = loadPreset(name)
+		exp.Snapshot = name
+		return exp, err
+	}
+
+	dec := schema.NewDecoder()
+	dec.IgnoreUnknownKeys(true)
+	err = dec.Decode(&exp, form)
+	return exp, err
+}
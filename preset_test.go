@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/ncruces/rethinkraw/internal/config"
+)
+
+func Test_decodeExportSettings(t *testing.T) {
+	dataDir := config.DataDir
+	config.DataDir = t.TempDir()
+	defer func() { config.DataDir = dataDir }()
+
+	err := savePreset("Web", exportSettings{Resample: true, Quality: 8, Fit: "dims", Long: 2048, DimUnit: "px"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp, err := decodeExportSettings(url.Values{"preset": {"Web"}, "quality": {"12"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Resample || exp.Quality != 8 || exp.Long != 2048 || exp.Snapshot != "Web" {
+		t.Errorf("decodeExportSettings() = %+v", exp)
+	}
+
+	exp, err = decodeExportSettings(url.Values{"resample": {"1"}, "quality": {"12"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Resample || exp.Quality != 12 || exp.Snapshot != "" {
+		t.Errorf("decodeExportSettings() = %+v", exp)
+	}
+
+	if _, err := decodeExportSettings(url.Values{"preset": {"../Web"}}); err == nil {
+		t.Error("decodeExportSettings() accepted an invalid name")
+	}
+	if err := savePreset("Bad", exportSettings{Metadata: "some"}); err == nil {
+		t.Error("savePreset() accepted an invalid metadata policy")
+	}
+}
+
+func Test_decodeExportRecipes(t *testing.T) {
+	dataDir := config.DataDir
+	config.DataDir = t.TempDir()
+	defer func() { config.DataDir = dataDir }()
+
+	for _, name := range []string{"Web", "Thumb"} {
+		if err := savePreset(name, exportSettings{Resample: true, Folder: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recipes, err := decodeExportRecipes(url.Values{"preset": {"Web", "Thumb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipes) != 2 || recipes[0].Folder != "Web" || recipes[1].Snapshot != "Thumb" {
+		t.Errorf("decodeExportRecipes() = %+v", recipes)
+	}
+
+	recipes, err = decodeExportRecipes(url.Values{"recipes": {`[{"dng":true},{"folder":"jpeg"}]`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipes) != 2 || !recipes[0].DNG || recipes[1].DNG || recipes[1].Folder != "jpeg" {
+		t.Errorf("decodeExportRecipes() = %+v", recipes)
+	}
+
+	for _, bad := range []string{
+		`[{"metadata":"bogus"}]`,
+		`[{"folder":"jpeg"},{"colorSpace":"ProPhotoRGB"}]`,
+		`[{"dng":true,"compat":"bogus"}]`,
+	} {
+		if _, err := decodeExportRecipes(url.Values{"recipes": {bad}}); err == nil {
+			t.Errorf("decodeExportRecipes(%s) accepted an invalid recipe", bad)
+		}
+	}
+
+	recipes, err = decodeExportRecipes(url.Values{"png": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipes) != 1 || !recipes[0].PNG {
+		t.Errorf("decodeExportRecipes() = %+v", recipes)
+	}
+}
+
+func Test_savePreset_sharpen(t *testing.T) {
+	dataDir := config.DataDir
+	config.DataDir = t.TempDir()
+	defer func() { config.DataDir = dataDir }()
+
+	tests := []exportSettings{
+		{Resample: true},
+		{Resample: true, Sharpen: "screen"},
+		{Resample: true, Sharpen: "matte", SharpenAmount: "low"},
+		{Resample: true, Sharpen: "glossy", SharpenAmount: "high", Density: 240, DenUnit: "ppi"},
+	}
+	for _, want := range tests {
+		if err := savePreset("Sharpen", want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadPreset("Sharpen")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("loadPreset() = %+v, want %+v", got, want)
+		}
+	}
+}
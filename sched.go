@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/ncruces/rethinkraw/internal/config"
+	"github.com/ncruces/rethinkraw/pkg/dcraw"
+	"github.com/ncruces/rethinkraw/pkg/osutil"
+)
+
+// Processing a photo can use a lot of memory, and temporary disk space
+// (mostly, for DNG conversion).
+// Batch jobs, previews and thumbnails share a pool of workers,
+// limited to the configured number (by default, the number of CPUs).
+// No new work is started while free memory, or temporary disk space, is low;
+// waiting work is reconsidered whenever running work ends.
+//
+// Work is scheduled by priority: interactive work (previews, thumbnails,
+// white balance picks) goes ahead of queued batch work,
+// and one slot is reserved for it, when there are more than one.
+// Concurrent batches take turns: the batch with the fewest items running goes next.
+// The same applies in front of the DNG converter, exiftool and dcraw.
+
+const (
+	workerMemory = 512 << 20 // memory needed by a worker
+	workerSpace  = 256 << 20 // temporary disk space needed by a worker
+)
+
+var (
+	workers           scheduler
+	schedDNGConverter scheduler
+	schedExifTool     scheduler
+	schedDcraw        scheduler
+)
+
+// setupWorkers sets the maximum number of workers (zero for the number of CPUs),
+// and scales the limits of external tools accordingly.
+// It must be called once, before any work is started.
+func setupWorkers(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	workers = scheduler{max: n, adaptive: true}
+	schedDNGConverter = scheduler{max: max(1, n/2)}
+	schedExifTool = scheduler{max: 1} // exiftool runs one command at a time
+	schedDcraw = scheduler{max: n}
+	dcraw.Concurrency = n
+}
+
+type batchKey struct{}
+
+// withBatch marks work as part of a batch, with lower priority.
+func withBatch(ctx context.Context, batch string) context.Context {
+	return context.WithValue(ctx, batchKey{}, batch)
+}
+
+// batchOf returns the batch work belongs to, or empty for interactive work.
+func batchOf(ctx context.Context) string {
+	batch, _ := ctx.Value(batchKey{}).(string)
+	return batch
+}
+
+type scheduler struct {
+	sync.Mutex
+	max      int
+	adaptive bool // limit by available resources
+	running  int
+	batches  map[string]int // items running, per batch
+	waiting  []*waiter
+}
+
+type waiter struct {
+	batch string
+	ready chan struct{}
+}
+
+// acquire waits for its turn to run work.
+func (s *scheduler) acquire(ctx context.Context) error {
+	w := &waiter{batch: batchOf(ctx), ready: make(chan struct{})}
+
+	s.Lock()
+	s.waiting = append(s.waiting, w)
+	s.dispatch()
+	s.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.Lock()
+		defer s.Unlock()
+		if !s.remove(w) {
+			// got its turn meanwhile: give it back
+			s.done(w.batch)
+			s.dispatch()
+		}
+		return ctx.Err()
+	}
+}
+
+// release ends work started with acquire, using the same context.
+func (s *scheduler) release(ctx context.Context) {
+	s.Lock()
+	defer s.Unlock()
+	s.done(batchOf(ctx))
+	s.dispatch()
+}
+
+// dispatch starts waiting work, in priority order; s must be locked.
+func (s *scheduler) dispatch() {
+	for len(s.waiting) > 0 {
+		limit := s.limit()
+		if s.running >= limit {
+			return
+		}
+
+		next := -1
+		for i, w := range s.waiting {
+			if w.batch == "" {
+				next = i
+				break
+			}
+			if next < 0 || s.batches[w.batch] < s.batches[s.waiting[next].batch] {
+				next = i
+			}
+		}
+
+		w := s.waiting[next]
+		if w.batch != "" && limit > 1 && s.running >= limit-1 {
+			return // reserved for interactive work
+		}
+
+		s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+		s.running++
+		if w.batch != "" {
+			if s.batches == nil {
+				s.batches = map[string]int{}
+			}
+			s.batches[w.batch]++
+		}
+		close(w.ready)
+	}
+}
+
+// done records the end of work; s must be locked.
+func (s *scheduler) done(batch string) {
+	s.running--
+	if batch != "" {
+		if s.batches[batch]--; s.batches[batch] <= 0 {
+			delete(s.batches, batch)
+		}
+	}
+}
+
+// remove removes a waiter; s must be locked.
+func (s *scheduler) remove(w *waiter) bool {
+	for i, o := range s.waiting {
+		if o == w {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// limit returns how much work can be running; s must be locked.
+// Adaptive schedulers are limited by available resources,
+// but always allow at least one.
+func (s *scheduler) limit() int {
+	n := s.max
+	if s.adaptive {
+		if mem := osutil.FreeMemory(); mem > 0 {
+			n = min(n, s.running+int(mem/workerMemory))
+		}
+		// the temporary directory is created on demand, so fallback to its parent
+		space := osutil.FreeSpace(config.TempDir)
+		if space == 0 {
+			space = osutil.FreeSpace(filepath.Dir(config.TempDir))
+		}
+		if space > 0 {
+			n = min(n, s.running+int(space/workerSpace))
+		}
+	}
+	return max(1, n)
+}
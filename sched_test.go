@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	setupWorkers(0)
+	os.Exit(m.Run())
+}
+
+func Test_scheduler(t *testing.T) {
+	ctx := context.Background()
+	s := scheduler{max: 1}
+	if err := s.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	s.release(ctx)
+
+	// batch a has an item running, and interactive work takes the other slots;
+	// queue batch a, batch b, and interactive work, in that order
+	a, b := withBatch(ctx, "a"), withBatch(ctx, "b")
+	s = scheduler{max: 3}
+	for _, ctx := range []context.Context{a, ctx, ctx} {
+		if err := s.acquire(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var order []string
+	done := make(chan struct{})
+	queue := func(ctx context.Context, name string) {
+		go func() {
+			if err := s.acquire(ctx); err != nil {
+				t.Error(err)
+			}
+			order = append(order, name)
+			s.release(ctx)
+			done <- struct{}{}
+		}()
+		for {
+			s.Lock()
+			n := len(s.waiting)
+			queued := n > 0 && s.waiting[n-1].batch == batchOf(ctx)
+			s.Unlock()
+			if queued {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	queue(a, "a")
+	queue(b, "b")
+	queue(ctx, "interactive")
+
+	s.release(ctx)
+	<-done
+	s.release(ctx)
+	<-done
+	<-done
+	s.release(a)
+
+	want := []string{"interactive", "b", "a"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+	if s.running != 0 || len(s.batches) != 0 {
+		t.Errorf("running = %d, batches = %v", s.running, s.batches)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// sharpenImage applies output sharpening to a resampled image.
+func sharpenImage(img image.Image, settings exportSettings) image.Image {
+	radius, amount := settings.sharpening(img.Bounds().Size())
+	if amount == 0 {
+		return img
+	}
+	return unsharpMask(img, radius, amount)
+}
+
+// sharpening returns the unsharp mask radius and amount for an image of the given size.
+//
+// The radius is scaled to the output medium:
+// for screens, to the output size; for paper, to the output density.
+// Matte paper spreads ink more than glossy, so it needs more sharpening.
+func (ex *exportSettings) sharpening(size image.Point) (radius, amount float64) {
+	switch ex.Sharpen {
+	case "screen":
+		long := math.Max(float64(size.X), float64(size.Y))
+		radius = 0.5 * math.Max(1, long/2048)
+		amount = 0.6
+	case "matte":
+		radius = 1.0 * ex.ppi() / 300
+		amount = 1.2
+	case "glossy":
+		radius = 0.7 * ex.ppi() / 300
+		amount = 0.9
+	default:
+		return 0, 0
+	}
+
+	switch ex.SharpenAmount {
+	case "low":
+		amount *= 0.5
+	case "high":
+		amount *= 1.5
+	}
+	return radius, amount
+}
+
+// ppi returns the output density in pixels per inch (300 if unspecified).
+func (ex *exportSettings) ppi() float64 {
+	switch {
+	case ex.DimUnit == "px" || ex.Density <= 0:
+		return 300
+	case ex.DenUnit == "ppi":
+		return float64(ex.Density)
+	default:
+		return float64(ex.Density) * 2.54
+	}
+}
+
+func unsharpMask(img image.Image, radius, amount float64) *image.RGBA {
+	src := image.NewRGBA(img.Bounds())
+	draw.Draw(src, src.Rect, img, img.Bounds().Min, draw.Src)
+	blur := image.NewRGBA(src.Rect)
+	copy(blur.Pix, src.Pix)
+	gaussianBlur(blur, radius)
+
+	// ignore differences below the threshold to avoid sharpening noise
+	const threshold = 2
+	for i := 0; i < len(src.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			diff := float64(src.Pix[i+c]) - float64(blur.Pix[i+c])
+			if math.Abs(diff) < threshold {
+				continue
+			}
+			v := float64(src.Pix[i+c]) + amount*diff
+			src.Pix[i+c] = uint8(math.Max(0, math.Min(255, v+0.5)))
+		}
+	}
+	return src
+}
+
+// gaussianBlur blurs, in place, the RGB channels of img with a separable Gaussian kernel.
+// Each row, then each column, is copied to a line buffer, and blurred back into img.
+func gaussianBlur(img *image.RGBA, sigma float64) {
+	n := int(math.Ceil(3 * sigma))
+	kernel := make([]float32, 2*n+1)
+	var sum float64
+	for i := range kernel {
+		x := float64(i - n)
+		v := math.Exp(-x * x / (2 * sigma * sigma))
+		kernel[i] = float32(v)
+		sum += v
+	}
+	for i := range kernel {
+		kernel[i] /= float32(sum)
+	}
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	line := make([]float32, 3*max(w, h))
+	blur := func(pix []byte, stride, count int) {
+		for i := 0; i < count; i++ {
+			p := pix[i*stride:]
+			line[3*i+0] = float32(p[0])
+			line[3*i+1] = float32(p[1])
+			line[3*i+2] = float32(p[2])
+		}
+		for i := 0; i < count; i++ {
+			var r, g, b float32
+			for k, kv := range kernel {
+				j := 3 * max(0, min(count-1, i+k-n))
+				r += kv * line[j+0]
+				g += kv * line[j+1]
+				b += kv * line[j+2]
+			}
+			p := pix[i*stride:]
+			p[0] = uint8(math.Min(255, float64(r)+0.5))
+			p[1] = uint8(math.Min(255, float64(g)+0.5))
+			p[2] = uint8(math.Min(255, float64(b)+0.5))
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		blur(img.Pix[y*img.Stride:], 4, w)
+	}
+	for x := 0; x < w; x++ {
+		blur(img.Pix[4*x:], img.Stride, h)
+	}
+}
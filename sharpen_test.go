@@ -0,0 +1,93 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func Test_exportSettings_sharpening(t *testing.T) {
+	tests := []struct {
+		name   string
+		exp    exportSettings
+		size   image.Point
+		radius float64
+		amount float64
+	}{
+		{"none", exportSettings{}, image.Pt(4096, 2048), 0, 0},
+		{"screen small", exportSettings{Sharpen: "screen"}, image.Pt(1024, 768), 0.5, 0.6},
+		{"screen large", exportSettings{Sharpen: "screen"}, image.Pt(3072, 4096), 1, 0.6},
+		{"matte", exportSettings{Sharpen: "matte"}, image.Pt(1024, 768), 1, 1.2},
+		{"matte 600 ppi", exportSettings{Sharpen: "matte", Density: 600, DenUnit: "ppi"}, image.Pt(1024, 768), 2, 1.2},
+		{"glossy", exportSettings{Sharpen: "glossy", SharpenAmount: "standard"}, image.Pt(1024, 768), 0.7, 0.9},
+		{"glossy ppc", exportSettings{Sharpen: "glossy", Density: 118, DenUnit: "ppc"}, image.Pt(1024, 768), 0.7 * 118 * 2.54 / 300, 0.9},
+		{"glossy pixels", exportSettings{Sharpen: "glossy", Density: 600, DenUnit: "ppi", DimUnit: "px"}, image.Pt(1024, 768), 0.7, 0.9},
+		{"low", exportSettings{Sharpen: "matte", SharpenAmount: "low"}, image.Pt(1024, 768), 1, 0.6},
+		{"high", exportSettings{Sharpen: "screen", SharpenAmount: "high"}, image.Pt(1024, 768), 0.5, 0.9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			radius, amount := tt.exp.sharpening(tt.size)
+			if math.Abs(radius-tt.radius) > 1e-9 || math.Abs(amount-tt.amount) > 1e-9 {
+				t.Errorf("sharpening() = %v, %v, want %v, %v", radius, amount, tt.radius, tt.amount)
+			}
+		})
+	}
+}
+
+// edgeImage returns a gray image, split in two vertical halves.
+func edgeImage(left, right uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			v := left
+			if x >= 8 {
+				v = right
+			}
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func Test_unsharpMask(t *testing.T) {
+	// a strong edge overshoots on both sides
+	img := unsharpMask(edgeImage(100, 150), 1, 1)
+	for y := 0; y < 8; y++ {
+		if l, r := img.RGBAAt(7, y), img.RGBAAt(8, y); l.R >= 100 || r.R <= 150 {
+			t.Fatalf("unsharpMask() edge = %v, %v", l, r)
+		}
+		if l, r := img.RGBAAt(0, y), img.RGBAAt(15, y); l.R != 100 || r.R != 150 {
+			t.Fatalf("unsharpMask() flat = %v, %v", l, r)
+		}
+	}
+
+	// differences below the threshold are left alone
+	src := edgeImage(100, 102)
+	img = unsharpMask(src, 1, 1)
+	for i := range src.Pix {
+		if img.Pix[i] != src.Pix[i] {
+			t.Fatalf("unsharpMask() changed a faint edge: %v", img.Pix)
+		}
+	}
+
+	// a zero amount is a no-op
+	src = edgeImage(0, 255)
+	img = unsharpMask(src, 2, 0)
+	for i := range src.Pix {
+		if img.Pix[i] != src.Pix[i] {
+			t.Fatalf("unsharpMask(amount 0) changed the image: %v", img.Pix)
+		}
+	}
+}
+
+func Test_sharpenImage(t *testing.T) {
+	src := edgeImage(0, 255)
+	if img := sharpenImage(src, exportSettings{Resample: true}); img != src {
+		t.Error("sharpenImage() without a medium should be a no-op")
+	}
+	if img := sharpenImage(src, exportSettings{Resample: true, Sharpen: "screen"}); img == src {
+		t.Error("sharpenImage() for screen should sharpen")
+	}
+}
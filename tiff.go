@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"math"
+	"os"
+
+	"github.com/ncruces/rethinkraw/pkg/dcraw"
+)
+
+// rgb48 is a 16-bit RGB image, with big-endian samples, as in a 16-bit PPM.
+type rgb48 struct {
+	Pix    []byte
+	Width  int
+	Height int
+}
+
+// developPPM develops a full resolution DNG into a 16-bit PPM.
+//
+// Camera Raw only renders 8-bit previews, so the DNG is developed by dcraw,
+// which knows nothing of Camera Raw settings:
+// only white balance (as shot or auto) and exposure are carried over.
+func developPPM(ctx context.Context, path string, xmp xmpSettings, cs *colorSpace) ([]byte, error) {
+	log.Print("dcraw (develop)...")
+	if err := schedDcraw.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer schedDcraw.release(ctx)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return dcraw.Develop(ctx, f, dcraw.DevelopOptions{
+		AutoWB:     xmp.WhiteBalance == "Auto",
+		Brightness: math.Exp2(float64(xmp.Exposure)),
+		ColorSpace: cs.dcraw,
+		Gamma:      [2]float64{cs.gamma, cs.slope},
+	})
+}
+
+// exportTIFF develops a full resolution DNG into a 16-bit TIFF, applying export settings.
+//
+// Color spaces dcraw doesn't support are developed in ProPhoto RGB, then converted.
+func exportTIFF(ctx context.Context, path string, xmp xmpSettings, settings exportSettings) ([]byte, error) {
+	cs := settings.colorSpace()
+	dev := cs
+	if dev.dcraw == 0 {
+		dev = &prophotoSpace
+	}
+
+	data, err := developPPM(ctx, path, xmp, dev)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodePPM16(data)
+	if err != nil {
+		return nil, err
+	}
+	if dev != cs {
+		convertRGB48(img, dev, cs)
+	}
+
+	if settings.Watermark != "" {
+		err = watermarkRGB48(img, settings.Watermark, cs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return encodeTIFF(img, settings), nil
+}
+
+// decodePPM16 decodes a PPM file in 16-bit P6 format, without copying pixels.
+func decodePPM16(data []byte) (*rgb48, error) {
+	var width, height int
+	n, _ := fmt.Fscanf(bytes.NewReader(data), "P6\n%d %d\n65535\n", &width, &height)
+	if n != 2 {
+		return nil, errors.New("not a 16-bit PPM file")
+	}
+	for i := 0; i < 3; i++ {
+		data = data[bytes.IndexByte(data, '\n')+1:]
+	}
+	if len(data) != 6*width*height {
+		return nil, errors.New("unsupported PPM file")
+	}
+	return &rgb48{Pix: data, Width: width, Height: height}, nil
+}
+
+// watermarkRGB48 stamps the named watermark, in place, on a 16-bit image in a color space.
+func watermarkRGB48(img *rgb48, name string, cs *colorSpace) error {
+	wm, err := loadWatermark(name)
+	if err != nil {
+		return err
+	}
+
+	stamp, pos, err := wm.render(image.Pt(img.Width, img.Height))
+	if err != nil {
+		return err
+	}
+
+	// convert colors without alpha, to avoid converting premultiplied values
+	alpha := image.NewNRGBA(stamp.Rect)
+	draw.Draw(alpha, alpha.Rect, stamp, image.Point{}, draw.Src)
+	opaque := &image.RGBA{Pix: append([]byte(nil), alpha.Pix...), Stride: alpha.Stride, Rect: alpha.Rect}
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 255
+	}
+	colors := convertImage(opaque, &srgbSpace, cs)
+
+	be := binary.BigEndian
+	opacity := wm.opacity()
+	rect := stamp.Rect.Add(pos).Intersect(image.Rect(0, 0, img.Width, img.Height))
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			s := colors.RGBAAt(x-pos.X, y-pos.Y)
+			a := opacity * float64(alpha.NRGBAAt(x-pos.X, y-pos.Y).A) / 255
+			if a == 0 {
+				continue
+			}
+			i := 6 * (y*img.Width + x)
+			for c, v := range [3]uint8{s.R, s.G, s.B} {
+				d := float64(be.Uint16(img.Pix[i+2*c:]))
+				d = 257*a*float64(v) + (1-a)*d
+				be.PutUint16(img.Pix[i+2*c:], uint16(math.Min(65535, d+0.5)))
+			}
+		}
+	}
+	return nil
+}
+
+// encodeTIFF encodes an image as a baseline, single strip,
+// Deflate compressed, 16-bit RGB TIFF.
+func encodeTIFF(img *rgb48, settings exportSettings) []byte {
+	var strip bytes.Buffer
+	z := zlib.NewWriter(&strip)
+	z.Write(img.Pix)
+	z.Close()
+
+	// resolution, in pixels per inch (2) or centimeter (3)
+	density, unit := uint32(72), uint16(2)
+	if settings.Resample && settings.hasDensity() {
+		density = uint32(settings.Density)
+		if settings.DenUnit != "ppi" {
+			unit = 3
+		}
+	}
+
+	const (
+		tShort    = 3
+		tLong     = 4
+		tRational = 5
+		entries   = 13
+		ifdOffset = 8
+		extra     = ifdOffset + 2 + 12*entries + 4 // bits per sample, and resolutions
+		pixels    = extra + 8 + 8 + 8
+	)
+
+	// big-endian, like the samples
+	be := binary.BigEndian
+	buf := make([]byte, pixels, pixels+strip.Len())
+	copy(buf, "MM\x00*")
+	be.PutUint32(buf[4:], ifdOffset)
+
+	ifd := buf[ifdOffset:]
+	be.PutUint16(ifd, entries)
+	entry := func(i int, tag, typ uint16, count, value uint32) {
+		e := ifd[2+12*i:]
+		be.PutUint16(e[0:], tag)
+		be.PutUint16(e[2:], typ)
+		be.PutUint32(e[4:], count)
+		if typ == tShort && count == 1 {
+			be.PutUint16(e[8:], uint16(value))
+		} else {
+			be.PutUint32(e[8:], value)
+		}
+	}
+	entry(0, 256, tLong, 1, uint32(img.Width))   // ImageWidth
+	entry(1, 257, tLong, 1, uint32(img.Height))  // ImageLength
+	entry(2, 258, tShort, 3, extra)              // BitsPerSample
+	entry(3, 259, tShort, 1, 8)                  // Compression: Deflate
+	entry(4, 262, tShort, 1, 2)                  // PhotometricInterpretation: RGB
+	entry(5, 273, tLong, 1, pixels)              // StripOffsets
+	entry(6, 277, tShort, 1, 3)                  // SamplesPerPixel
+	entry(7, 278, tLong, 1, uint32(img.Height))  // RowsPerStrip
+	entry(8, 279, tLong, 1, uint32(strip.Len())) // StripByteCounts
+	entry(9, 282, tRational, 1, extra+8)         // XResolution
+	entry(10, 283, tRational, 1, extra+16)       // YResolution
+	entry(11, 284, tShort, 1, 1)                 // PlanarConfiguration: chunky
+	entry(12, 296, tShort, 1, uint32(unit))      // ResolutionUnit
+
+	be.PutUint16(buf[extra+0:], 16)
+	be.PutUint16(buf[extra+2:], 16)
+	be.PutUint16(buf[extra+4:], 16)
+	for _, off := range []int{extra + 8, extra + 16} {
+		be.PutUint32(buf[off+0:], density)
+		be.PutUint32(buf[off+4:], 1)
+	}
+
+	return append(buf, strip.Bytes()...)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+func Test_encodeTIFF(t *testing.T) {
+	const width, height = 33, 17
+	ppm := []byte(fmt.Sprintf("P6\n%d %d\n65535\n", width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			ppm = binary.BigEndian.AppendUint16(ppm, uint16(1999*x))
+			ppm = binary.BigEndian.AppendUint16(ppm, uint16(3989*y))
+			ppm = binary.BigEndian.AppendUint16(ppm, uint16(257*x+y))
+		}
+	}
+
+	img, err := decodePPM16(ppm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := encodeTIFF(img, exportSettings{Resample: true, Density: 300, DenUnit: "ppi"})
+
+	// check the tags that were written
+	be := binary.BigEndian
+	if !bytes.HasPrefix(data, []byte("MM\x00*")) {
+		t.Fatalf("encodeTIFF() header = %q", data[:4])
+	}
+	ifd := data[be.Uint32(data[4:]):]
+	tags := map[uint16][]uint32{}
+	for i := 0; i < int(be.Uint16(ifd)); i++ {
+		e := ifd[2+12*i:]
+		typ, count, value := be.Uint16(e[2:]), be.Uint32(e[4:]), be.Uint32(e[8:])
+		switch {
+		case typ == 3 && count == 1:
+			tags[be.Uint16(e)] = []uint32{uint32(be.Uint16(e[8:]))}
+		case typ == 3:
+			for j := uint32(0); j < count; j++ {
+				tags[be.Uint16(e)] = append(tags[be.Uint16(e)], uint32(be.Uint16(data[value+2*j:])))
+			}
+		case typ == 5:
+			tags[be.Uint16(e)] = []uint32{be.Uint32(data[value:]), be.Uint32(data[value+4:])}
+		default:
+			tags[be.Uint16(e)] = []uint32{value}
+		}
+	}
+	want := map[uint16][]uint32{
+		256: {width},
+		257: {height},
+		258: {16, 16, 16},
+		259: {8},
+		262: {2},
+		277: {3},
+		282: {300, 1},
+		283: {300, 1},
+		296: {2},
+	}
+	for tag, w := range want {
+		if fmt.Sprint(tags[tag]) != fmt.Sprint(w) {
+			t.Errorf("encodeTIFF() tag %d = %v, want %v", tag, tags[tag], w)
+		}
+	}
+
+	// check the samples kept their depth
+	out, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba, ok := out.(*image.RGBA64)
+	if !ok {
+		t.Fatalf("encodeTIFF() decodes to %T, want 16-bit", out)
+	}
+	if rgba.Bounds() != image.Rect(0, 0, width, height) {
+		t.Fatalf("encodeTIFF() bounds = %v", rgba.Bounds())
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := rgba.RGBA64At(x, y)
+			if c.R != uint16(1999*x) || c.G != uint16(3989*y) || c.B != uint16(257*x+y) {
+				t.Fatalf("encodeTIFF() pixel (%d, %d) = %v", x, y, c)
+			}
+		}
+	}
+}
+
+func Test_decodePPM16(t *testing.T) {
+	for _, ppm := range []string{
+		"P6\n2 1\n255\n\x00\x00\x00\x00\x00\x00",
+		"P5\n1 1\n65535\n\x00\x00",
+		"P6\n2 2\n65535\n\x00\x00\x00\x00\x00\x00",
+	} {
+		if _, err := decodePPM16([]byte(ppm)); err == nil {
+			t.Errorf("decodePPM16(%q) = nil error", ppm)
+		}
+	}
+}
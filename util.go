@@ -0,0 +1,17 @@
+package main
+
+// The min and max builtins need Go 1.21.
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// A watermark definition.
+//
+// Watermarks are either text, or a PNG image (with alpha).
+// Offsets are relative to the short side of the photo,
+// and size is relative to the photo's width.
+// Rotation is counterclockwise, in degrees.
+type watermark struct {
+	Text     string  `json:"text,omitempty"`
+	Color    string  `json:"color,omitempty"` // text color, as #RRGGBB
+	Image    []byte  `json:"image,omitempty"` // PNG data
+	Anchor   string  `json:"anchor"`          // n, ne, e, se, s, sw, w, nw, or c
+	OffsetX  float64 `json:"offsetX"`
+	OffsetY  float64 `json:"offsetY"`
+	Size     float64 `json:"size"`
+	Opacity  float64 `json:"opacity"`
+	Rotation float64 `json:"rotation"`
+}
+
+func listWatermarks() ([]string, error) {
+	return listData("watermarks")
+}
+
+func loadWatermark(name string) (wm watermark, err error) {
+	err = loadData("watermarks", name, &wm)
+	return wm, err
+}
+
+func saveWatermark(name string, wm watermark) error {
+	if wm.Text == "" && len(wm.Image) == 0 {
+		return errors.New("watermark needs either text or an image")
+	}
+	if len(wm.Image) > 0 {
+		if _, err := png.DecodeConfig(bytes.NewReader(wm.Image)); err != nil {
+			return err
+		}
+	}
+	return saveData("watermarks", name, wm)
+}
+
+func deleteWatermark(name string) error {
+	return deleteData("watermarks", name)
+}
+
+// applyWatermark stamps the named watermark on a (sRGB) image.
+func applyWatermark(img image.Image, name string) (image.Image, error) {
+	wm, err := loadWatermark(name)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	stamp, pos, err := wm.render(bounds.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	mask := image.NewUniform(color.Alpha{uint8(255*wm.opacity() + 0.5)})
+	draw.DrawMask(rgba, stamp.Bounds().Add(pos).Add(bounds.Min), stamp, image.Point{}, mask, image.Point{}, draw.Over)
+	return rgba, nil
+}
+
+func (wm *watermark) opacity() float64 {
+	if wm.Opacity <= 0 || wm.Opacity > 1 {
+		return 1
+	}
+	return wm.Opacity
+}
+
+// render renders the watermark for a photo of the given size.
+// It returns the (premultiplied) stamp, and where to place it.
+func (wm *watermark) render(size image.Point) (*image.RGBA, image.Point, error) {
+	rel := wm.Size
+	if rel <= 0 || rel > 1 {
+		rel = 0.25
+	}
+	width := math.Max(1, rel*float64(size.X))
+
+	var src image.Image
+	if len(wm.Image) > 0 {
+		img, err := png.Decode(bytes.NewReader(wm.Image))
+		if err != nil {
+			return nil, image.Point{}, err
+		}
+		src = img
+	} else {
+		img, err := wm.renderText(width)
+		if err != nil {
+			return nil, image.Point{}, err
+		}
+		src = img
+	}
+
+	// scale, then rotate around the center
+	sb := src.Bounds()
+	scale := width / float64(sb.Dx())
+	w, h := scale*float64(sb.Dx()), scale*float64(sb.Dy())
+	sin, cos := math.Sincos(wm.Rotation * math.Pi / 180)
+	rw := math.Abs(w*cos) + math.Abs(h*sin)
+	rh := math.Abs(w*sin) + math.Abs(h*cos)
+
+	stamp := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(rw)), int(math.Ceil(rh))))
+	s2d := f64.Aff3{
+		scale * cos, scale * sin, rw/2 - scale*(cos*float64(sb.Min.X)+sin*float64(sb.Min.Y)) - (cos*w+sin*h)/2,
+		-scale * sin, scale * cos, rh/2 - scale*(cos*float64(sb.Min.Y)-sin*float64(sb.Min.X)) - (cos*h-sin*w)/2,
+	}
+	draw.CatmullRom.Transform(stamp, s2d, src, sb, draw.Over, nil)
+
+	// position
+	short := math.Min(float64(size.X), float64(size.Y))
+	dx, dy := int(wm.OffsetX*short+0.5), int(wm.OffsetY*short+0.5)
+	free := size.Sub(stamp.Rect.Size())
+	var pos image.Point
+
+	anchor := strings.ToLower(wm.Anchor)
+	switch {
+	case strings.Contains(anchor, "w"):
+		pos.X = dx
+	case strings.Contains(anchor, "e"):
+		pos.X = free.X - dx
+	default:
+		pos.X = free.X/2 + dx
+	}
+	switch {
+	case strings.Contains(anchor, "n"):
+		pos.Y = dy
+	case strings.Contains(anchor, "s"):
+		pos.Y = free.Y - dy
+	default:
+		pos.Y = free.Y/2 + dy
+	}
+	return stamp, pos, nil
+}
+
+// renderText renders the watermark text, roughly width pixels wide.
+func (wm *watermark) renderText(width float64) (*image.RGBA, error) {
+	fnt, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+
+	// measure at a nominal size, then scale to fit
+	const nominal = 100
+	face, err := opentype.NewFace(fnt, &opentype.FaceOptions{Size: nominal, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	adv := font.MeasureString(face, wm.Text)
+	face.Close()
+	if adv <= 0 {
+		return nil, errors.New("empty watermark text")
+	}
+
+	size := math.Max(1, nominal*width/(float64(adv)/64))
+	face, err = opentype.NewFace(fnt, &opentype.FaceOptions{Size: size, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	adv = font.MeasureString(face, wm.Text)
+	img := image.NewRGBA(image.Rect(0, 0, adv.Ceil(), (metrics.Ascent + metrics.Descent).Ceil()))
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(wm.textColor()),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+	drawer.DrawString(wm.Text)
+	return img, nil
+}
+
+func (wm *watermark) textColor() color.Color {
+	var r, g, b uint8
+	if n, _ := fmt.Sscanf(wm.Color, "#%02x%02x%02x", &r, &g, &b); n == 3 {
+		return color.RGBA{r, g, b, 255}
+	}
+	return color.White
+}
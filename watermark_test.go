@@ -0,0 +1,33 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func Test_watermark_render(t *testing.T) {
+	tests := []struct {
+		anchor string
+		want   func(pos, free image.Point) bool
+	}{
+		{"nw", func(pos, free image.Point) bool { return pos == image.Pt(10, 10) }},
+		{"se", func(pos, free image.Point) bool { return pos == free.Sub(image.Pt(10, 10)) }},
+		{"c", func(pos, free image.Point) bool { return pos == free.Div(2).Add(image.Pt(10, 10)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.anchor, func(t *testing.T) {
+			wm := watermark{Text: "RethinkRAW", Anchor: tt.anchor, OffsetX: 0.01, OffsetY: 0.01, Size: 0.5}
+			size := image.Pt(2000, 1000)
+			stamp, pos, err := wm.render(size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w := stamp.Rect.Dx(); w < 990 || w > 1010 {
+				t.Errorf("render() width = %d, want ~1000", w)
+			}
+			if free := size.Sub(stamp.Rect.Size()); !tt.want(pos, free) {
+				t.Errorf("render() pos = %v, free = %v", pos, free)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"sync"
@@ -27,6 +28,11 @@ import (
 //  . orig.EXT - a read-only copy of the original RAW file
 //  . orig.xmp - a sidecar for orig.EXT
 //  . temp.dng - a DNG used as the target for all conversions
+//  . render.dng - a full resolution DNG, with edits, rendered for export
+//  . temp.jpg - a JPEG used as the target for exports
+//  . temp.png - a PNG used as the target for lossless exports
+//  . temp.tif - a TIFF used as the target for 16-bit exports
+//  . temp.icc - an ICC profile for the export color space
 //  . edit.dng - a DNG conversion of the original RAW file used for editing previews
 //
 // Editing settings are loaded from orig.xmp or orig.EXT (in that order).
@@ -42,7 +48,7 @@ type workspace struct {
 	hasEdit   bool   // any recent edits?
 }
 
-func openWorkspace(path string) (wk workspace, err error) {
+func openWorkspace(ctx context.Context, path string) (wk workspace, err error) {
 	wk.hash = util.HashedID(filepath.Clean(path))
 	wk.ext = filepath.Ext(path)
 	wk.base = filepath.Join(config.TempDir, wk.hash) + string(filepath.Separator)
@@ -85,7 +91,7 @@ func openWorkspace(path string) (wk workspace, err error) {
 	}
 
 	// and load a sidecar for it
-	err = loadSidecar(path, wk.base+"orig.xmp")
+	err = loadSidecar(ctx, path, wk.base+"orig.xmp")
 	return wk, err
 }
 
@@ -105,11 +111,31 @@ func (wk *workspace) temp() string {
 	return wk.base + "temp.dng"
 }
 
+// A full resolution DNG, with edits, rendered for export.
+func (wk *workspace) render() string {
+	return wk.base + "render.dng"
+}
+
 // A JPG used as the target for export.
 func (wk *workspace) jpeg() string {
 	return wk.base + "temp.jpg"
 }
 
+// A PNG used as the target for export.
+func (wk *workspace) png() string {
+	return wk.base + "temp.png"
+}
+
+// An ICC profile to embed in exports.
+func (wk *workspace) icc() string {
+	return wk.base + "temp.icc"
+}
+
+// A TIFF used as the target for export.
+func (wk *workspace) tiff() string {
+	return wk.base + "temp.tif"
+}
+
 // A DNG conversion of the original RAW file used for editing previews (downscaled to 2560).
 func (wk *workspace) edit() string {
 	return wk.base + "edit.dng"
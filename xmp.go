@@ -58,9 +58,9 @@ type xmpWhiteBalance struct {
 	Tint        int `json:"tint"`
 }
 
-func loadXMP(path string) (xmp xmpSettings, err error) {
+func loadXMP(ctx context.Context, path string) (xmp xmpSettings, err error) {
 	log.Print("exiftool (load xmp)...")
-	out, err := exifserver.Command("--printConv", "-short2", "-fast2",
+	out, err := exifCommand(ctx, "--printConv", "-short2", "-fast2",
 		"-Orientation", "-Make", "-Model", "-XMP-crs:all", path)
 	if err != nil {
 		return xmp, err
@@ -153,7 +153,7 @@ func loadXMP(path string) (xmp xmpSettings, err error) {
 	return xmp, nil
 }
 
-func editXMP(path string, xmp xmpSettings) error {
+func editXMP(ctx context.Context, path string, xmp xmpSettings) error {
 	// no process means don't edit
 	if xmp.Process == 0 {
 		return nil
@@ -290,23 +290,23 @@ func editXMP(path string, xmp xmpSettings) error {
 	opts = append(opts, "-overwrite_original", path)
 
 	log.Print("exiftool (edit xmp)...")
-	_, err := exifserver.Command(opts...)
+	_, err := exifCommand(ctx, opts...)
 	return err
 }
 
-func extractXMP(path, dest string) error {
+func extractXMP(ctx context.Context, path, dest string) error {
 	log.Print("exiftool (extract xmp)...")
-	_, err := exifserver.Command("--printConv", "-fast2",
+	_, err := exifCommand(ctx, "--printConv", "-fast2",
 		"-tagsFromFile", path, "-scanForXMP",
 		"-Orientation", "-Make", "-Model", "-all:all",
 		"-overwrite_original", dest)
 	return err
 }
 
-func computeWhiteBalance(meta, pixels string, coords []float64) (wb xmpWhiteBalance, err error) {
+func computeWhiteBalance(ctx context.Context, meta, pixels string, coords []float64) (wb xmpWhiteBalance, err error) {
 	log.Print("exiftool (load camera profile)...")
 
-	out, err := exifserver.Command("--printConv", "-short2", "-fast2",
+	out, err := exifCommand(ctx, "--printConv", "-short2", "-fast2",
 		"-EXIF:CalibrationIlluminant?", "-EXIF:ColorMatrix?",
 		"-EXIF:CameraCalibration?", "-EXIF:AnalogBalance",
 		"-EXIF:AsShotNeutral", "-EXIF:AsShotWhiteXY", meta)
@@ -480,6 +480,10 @@ func loadFloat64s(dst *[]float64, m map[string][]byte, key string) {
 
 func dngPreview(ctx context.Context, path string) string {
 	log.Print("dcraw (get thumb size)...")
+	if err := schedDcraw.acquire(ctx); err != nil {
+		return ""
+	}
+	defer schedDcraw.release(ctx)
 	f, err := os.Open(path)
 	if err != nil {
 		return ""
@@ -502,6 +506,10 @@ func dngPreview(ctx context.Context, path string) string {
 
 func getRawPixels(ctx context.Context, path, dest string) error {
 	log.Print("dcraw (get raw pixels)...")
+	if err := schedDcraw.acquire(ctx); err != nil {
+		return err
+	}
+	defer schedDcraw.release(ctx)
 	f, err := os.Open(path)
 	if err != nil {
 		return err